@@ -3,16 +3,25 @@
 package atomicwriter
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/moby/sys/sequential"
+	"github.com/moby/sys/symlink"
 )
 
+// errExchangeNotSupported is returned by exchange if atomically exchanging
+// two paths is not supported.
+var errExchangeNotSupported = errors.New("exchanging paths is not supported")
+
 func validateDestination(fileName string) error {
 	if fileName == "" {
 		return errors.New("file name is empty")
@@ -36,8 +45,13 @@ func validateDestination(fileName string) error {
 		}
 		return fmt.Errorf("failed to stat output path: %w", err)
 	}
+	return validateMode(fi.Mode())
+}
 
-	switch mode := fi.Mode(); {
+// validateMode returns an error if a file with the given mode cannot be
+// atomically replaced.
+func validateMode(mode os.FileMode) error {
+	switch {
 	case mode.IsRegular():
 		return nil // Regular file
 	case mode&os.ModeDir != 0:
@@ -67,15 +81,55 @@ func validateDestination(fileName string) error {
 // New returns a WriteCloser so that writing to it writes to a
 // temporary file and closing it atomically changes the temporary file to
 // destination path. Writing and closing concurrently is not allowed.
-// NOTE: umask is not considered for the file's permissions.
+// NOTE: umask is not considered for the file's permissions, unless the
+// [WithUmask] option is used.
 //
-// New uses [sequential.CreateTemp] to use sequential file access on Windows,
-// avoiding depleting the standby list un-necessarily. On Linux, this equates to
-// a regular [os.CreateTemp]. Refer to the [Win32 API documentation] for details
-// on sequential file access.
+// Options can be passed to change the default behavior; for example,
+// [WithSyncDir] to make the rename durable. New is equivalent to calling
+// [NewWithOptions] with [WithMode] set to perm.
+//
+// On Linux, New creates an anonymous temporary file using O_TMPFILE, which is
+// linked into place when closing, so that no temporary file is left behind
+// if the process crashes, and no temporary name is visible to other processes
+// when creating a new file. When replacing an existing file, the file is
+// briefly linked under a temporary name before it is renamed over the
+// destination, as linkat(2) cannot replace an existing file. If O_TMPFILE is
+// not supported by the filesystem, a named temporary file is used instead.
+//
+// Elsewhere, New uses [sequential.CreateTemp] to use sequential file access on
+// Windows, avoiding depleting the standby list un-necessarily. On other
+// platforms, this equates to a regular [os.CreateTemp]. Refer to the
+// [Win32 API documentation] for details on sequential file access.
 //
 // [Win32 API documentation]: https://learn.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-createfilea#FILE_FLAG_SEQUENTIAL_SCAN
-func New(filename string, perm os.FileMode) (io.WriteCloser, error) {
+func New(filename string, perm os.FileMode, opts ...Option) (io.WriteCloser, error) {
+	return NewWithOptions(filename, append([]Option{WithMode(perm)}, opts...)...)
+}
+
+// NewWithOptions is like [New], but takes all file attributes as options.
+// The mode, ownership, extended attributes and timestamps set through
+// [WithMode], [WithUmask], [WithChown], [WithXattrs] and [WithTimes] are
+// applied to the temporary file before it is renamed into place, so readers
+// never observe a partially configured file.
+func NewWithOptions(filename string, opts ...Option) (io.WriteCloser, error) {
+	w, err := NewWriter(filename, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriter is like [NewWithOptions], but returns a [*Writer], which allows
+// the caller to explicitly commit or abort the write.
+func NewWriter(filename string, opts ...Option) (*Writer, error) {
+	o := applyOptions(opts)
+	if o.followSymlinks && filename != "" {
+		var err error
+		filename, err = resolveSymlinks(filename, o.symlinkRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if err := validateDestination(filename); err != nil {
 		return nil, err
 	}
@@ -84,82 +138,419 @@ func New(filename string, perm os.FileMode) (io.WriteCloser, error) {
 		return nil, err
 	}
 
-	f, err := sequential.CreateTemp(filepath.Dir(abspath), ".tmp-"+filepath.Base(filename))
+	f, anonymous, err := createTemp(filepath.Dir(abspath), tempPattern(tempFilePrefix, filepath.Base(filename)))
 	if err != nil {
 		return nil, err
 	}
-	return &atomicFileWriter{
-		f:    f,
-		fn:   abspath,
-		perm: perm,
-	}, nil
+	return start(&Writer{
+		f:         f,
+		fn:        abspath,
+		anonymous: anonymous,
+		opts:      o,
+	})
+}
+
+// start prepares the newly created temporary file of w for writing. The
+// temporary file is discarded if that fails.
+func start(w *Writer) (*Writer, error) {
+	if w.opts.digestHash != nil {
+		w.opts.digestHash.Reset()
+	}
+	if w.opts.preallocate > 0 {
+		if err := preallocate(w.f, w.opts.preallocate); err != nil {
+			_ = w.Abort()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// resolveSymlinks resolves all symlinks in fileName within the given root.
+// If root is empty, the root of the filesystem (or volume) is used.
+func resolveSymlinks(fileName, root string) (string, error) {
+	abspath, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+	if root == "" {
+		root = filepath.VolumeName(abspath) + string(filepath.Separator)
+	}
+	resolved, err := symlink.FollowSymlinkInScope(abspath, root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlinks: %w", err)
+	}
+	return resolved, nil
 }
 
 // WriteFile atomically writes data to a file named by filename and with the
 // specified permission bits. The given filename is created if it does not exist,
 // but the destination directory must exist. It can be used as a drop-in replacement
-// for [os.WriteFile], but currently does not allow the destination path to be
-// a symlink. WriteFile is implemented using [New] for its implementation.
+// for [os.WriteFile], but does not allow the destination path to be a symlink,
+// unless the [WithFollowSymlinks] option is used. WriteFile is implemented
+// using [New] for its implementation.
 //
-// NOTE: umask is not considered for the file's permissions.
-func WriteFile(filename string, data []byte, perm os.FileMode) error {
-	f, err := New(filename, perm)
+// NOTE: umask is not considered for the file's permissions, unless the
+// [WithUmask] option is used.
+func WriteFile(filename string, data []byte, perm os.FileMode, opts ...Option) error {
+	w, err := NewWriter(filename, append([]Option{WithMode(perm)}, opts...)...)
 	if err != nil {
 		return err
 	}
-	n, err := f.Write(data)
+	return writeAndCommit(w, data)
+}
+
+// writeAndCommit writes data to w and commits it, so that empty files are
+// written as well.
+func writeAndCommit(w *Writer, data []byte) error {
+	n, err := w.Write(data)
 	if err == nil && n < len(data) {
 		err = io.ErrShortWrite
-		f.(*atomicFileWriter).writeErr = err
 	}
-	if err1 := f.Close(); err == nil {
-		err = err1
+	if err != nil {
+		_ = w.Abort()
+		return err
 	}
-	return err
+	return w.Commit()
 }
 
-type atomicFileWriter struct {
-	f        *os.File
-	fn       string
+// Writer writes to a temporary file, which atomically replaces the
+// destination when the write is committed. It is returned by [NewWriter]
+// and [Dir.NewWriter], and is the [io.WriteCloser] returned by [New],
+// [NewWithOptions] and [Dir.New].
+//
+// A Writer must be finished by calling exactly one of [Writer.Commit],
+// [Writer.Abort] or [Writer.Close]. Calling Abort after the Writer was
+// finished is a no-op, so that it can be deferred. A Writer must not be used
+// concurrently.
+type Writer struct {
+	f         *os.File
+	fn        string
+	anonymous bool // f is an anonymous (O_TMPFILE) file that has no name yet.
+
+	// dir is the parent directory of the destination for writers created
+	// by [Dir.New]. The destination and the temporary file are then referred
+	// to by their names relative to dir, and fn is only used for errors.
+	dir     *os.File
+	name    string
+	tmpName string
+
 	writeErr error
 	written  bool
-	perm     os.FileMode
+	done     bool
+	opts     options
 }
 
-func (w *atomicFileWriter) Write(dt []byte) (int, error) {
+// Write writes to the temporary file. If writing fails, the write can no
+// longer be committed.
+func (w *Writer) Write(dt []byte) (int, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
 	w.written = true
 	n, err := w.f.Write(dt)
 	if err != nil {
 		w.writeErr = err
 	}
+	if w.opts.digestHash != nil {
+		w.opts.digestHash.Write(dt[:n])
+	}
 	return n, err
 }
 
-func (w *atomicFileWriter) Close() (retErr error) {
-	defer func() {
-		if err := os.Remove(w.f.Name()); !errors.Is(err, os.ErrNotExist) && retErr == nil {
-			retErr = err
+// ReadFrom implements [io.ReaderFrom]. It copies from r to the temporary
+// file until EOF, using copy_file_range(2) or splice(2) where possible. If
+// an error occurs, including an error reading from r, the write can no
+// longer be committed.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	w.written = true
+	var (
+		n   int64
+		err error
+	)
+	if w.opts.digestHash != nil {
+		n, err = io.Copy(io.MultiWriter(w.f, w.opts.digestHash), r)
+	} else {
+		n, err = w.f.ReadFrom(r)
+	}
+	if err != nil {
+		w.writeErr = err
+	}
+	return n, err
+}
+
+// errNotSequential is returned by [Writer.Seek] and [Writer.Truncate] if the
+// digest of the content is verified, which requires sequential writes.
+var errNotSequential = errors.New("cannot seek or truncate when verifying the digest of the content")
+
+// Seek sets the offset for the next write to the temporary file, as for
+// [os.File.Seek]. Seeking is not supported with [WithExpectedDigest], except
+// to query the current offset.
+func (w *Writer) Seek(offset int64, whence int) (int64, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	if w.opts.digestHash != nil && (offset != 0 || whence != io.SeekCurrent) {
+		return 0, errNotSequential
+	}
+	return w.f.Seek(offset, whence)
+}
+
+// Truncate changes the size of the temporary file, as for [os.File.Truncate].
+// It does not change the offset for the next write. Truncating is not
+// supported with [WithExpectedDigest].
+func (w *Writer) Truncate(size int64) error {
+	if w.done {
+		return os.ErrClosed
+	}
+	if w.opts.digestHash != nil {
+		return errNotSequential
+	}
+	w.written = true
+	if err := w.f.Truncate(size); err != nil {
+		w.writeErr = err
+		return err
+	}
+	return nil
+}
+
+// verify verifies the size and digest of the content written, if requested.
+func (w *Writer) verify() error {
+	if w.opts.expectedSize < 0 && w.opts.digestHash == nil {
+		return nil
+	}
+	fi, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if w.opts.expectedSize >= 0 && size != w.opts.expectedSize {
+		return &MismatchError{Filename: w.fn, ExpectedSize: w.opts.expectedSize, Size: size}
+	}
+	if w.opts.digestHash != nil {
+		if digest := w.opts.digestHash.Sum(nil); !bytes.Equal(digest, w.opts.expectedDigest) {
+			return &MismatchError{
+				Filename:       w.fn,
+				DigestMismatch: true,
+				ExpectedSize:   w.opts.expectedSize,
+				Size:           size,
+				ExpectedDigest: w.opts.expectedDigest,
+				Digest:         digest,
+			}
 		}
-	}()
+	}
+	return nil
+}
+
+// Commit atomically replaces the destination with the content written,
+// including if nothing was written, in which case the destination is
+// replaced with an empty file. If an earlier write failed, the write is
+// aborted instead, and the error of that write is returned.
+func (w *Writer) Commit() error {
+	if w.done {
+		return os.ErrClosed
+	}
+	if w.writeErr != nil {
+		_ = w.finish(false)
+		return w.writeErr
+	}
+	return w.finish(true)
+}
+
+// Abort discards the content written, and leaves the destination unchanged.
+// Calling Abort after the Writer was finished is a no-op.
+func (w *Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	return w.finish(false)
+}
+
+// Close commits the write if content was written without errors, and aborts
+// it otherwise. Use [Writer.Commit] to replace the destination with an empty
+// file.
+func (w *Writer) Close() error {
+	if w.done {
+		return os.ErrClosed
+	}
+	return w.finish(w.writeErr == nil && w.written)
+}
+
+// finish commits or aborts the write, and closes the temporary file.
+func (w *Writer) finish(commit bool) (retErr error) {
+	w.done = true
+	if w.dir != nil {
+		defer w.dir.Close()
+	}
+	if !w.anonymous {
+		defer func() {
+			if err := w.removeTemp(); !errors.Is(err, os.ErrNotExist) && retErr == nil {
+				retErr = err
+			}
+		}()
+	}
+	if commit {
+		if err := w.verify(); err != nil {
+			_ = w.f.Close()
+			return err
+		}
+		if err := w.setMetadata(); err != nil {
+			_ = w.f.Close()
+			return err
+		}
+	}
 	if err := w.f.Sync(); err != nil {
 		_ = w.f.Close()
 		return err
 	}
+	var bak string
+	if commit && w.opts.backup {
+		var err error
+		if bak, err = prepareBackup(w.fn); err != nil {
+			_ = w.f.Close()
+			return err
+		}
+		if bak != "" {
+			// Remove the preserved version if it was not moved into place.
+			defer func() { _ = os.Remove(bak) }()
+		}
+	}
+	if commit && w.anonymous {
+		if err := w.linkTemp(); err != nil {
+			_ = w.f.Close()
+			return err
+		}
+	}
 	if err := w.f.Close(); err != nil {
 		return err
 	}
-	if err := os.Chmod(w.f.Name(), w.perm); err != nil {
+	if !commit {
+		return nil
+	}
+	if !w.anonymous {
+		if err := w.rename(); err != nil {
+			return err
+		}
+	}
+	if bak != "" {
+		if err := commitBackup(w.fn, bak, w.opts.backupRotate); err != nil {
+			return err
+		}
+	}
+	if w.opts.syncDir {
+		dir := filepath.Dir(w.fn)
+		if err := w.syncDir(); err != nil {
+			return &SyncDirError{Dir: dir, Err: err}
+		}
+	}
+	return nil
+}
+
+// removeTemp removes the named temporary file, if it still exists.
+func (w *Writer) removeTemp() error {
+	if w.dir != nil {
+		return removeAt(w.dir, w.tmpName)
+	}
+	return os.Remove(w.f.Name())
+}
+
+// linkTemp links the anonymous temporary file into place.
+func (w *Writer) linkTemp() error {
+	if w.dir != nil {
+		return linkTempAt(w.f, w.dir, w.name)
+	}
+	return linkTemp(w.f, w.fn)
+}
+
+// rename renames the named temporary file into place.
+func (w *Writer) rename() error {
+	if w.dir != nil {
+		return renameAt(w.dir, w.tmpName, w.name)
+	}
+	return os.Rename(w.f.Name(), w.fn)
+}
+
+// syncDir syncs the parent directory of the destination.
+func (w *Writer) syncDir() error {
+	if w.dir != nil {
+		return w.dir.Sync()
+	}
+	return syncDir(filepath.Dir(w.fn))
+}
+
+// setMetadata applies the ownership, mode, extended attributes and timestamps
+// to the temporary file. Ownership is changed first, as changing it may clear
+// the setuid and setgid bits.
+func (w *Writer) setMetadata() error {
+	chown, uid, gid := w.opts.chown, w.opts.uid, w.opts.gid
+	mode := w.opts.mode
+	if w.opts.umask {
+		mode &^= umask()
+	}
+	xattrs := w.opts.xattrs
+	if w.opts.preserve {
+		fi, err := os.Lstat(w.fn)
+		switch {
+		case err == nil && fi.Mode().IsRegular():
+			mode = fi.Mode().Perm()
+			if fuid, fgid, ok := fileOwner(fi); ok {
+				chown, uid, gid = true, fuid, fgid
+			}
+			preserved, err := getXattrs(w.fn)
+			if err != nil {
+				return err
+			}
+			for k, v := range w.opts.xattrs {
+				preserved[k] = v
+			}
+			xattrs = preserved
+		case err != nil && !os.IsNotExist(err):
+			return err
+		}
+	}
+
+	if chown {
+		if err := w.f.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err := w.f.Chmod(mode); err != nil {
+		return err
+	}
+	if err := setXattrs(w.f, xattrs); err != nil {
 		return err
 	}
-	if w.writeErr == nil && w.written {
-		return os.Rename(w.f.Name(), w.fn)
+	if w.opts.chtimes {
+		atime, mtime := w.opts.atime, w.opts.mtime
+		if atime.IsZero() || mtime.IsZero() {
+			fi, err := w.f.Stat()
+			if err != nil {
+				return err
+			}
+			if atime.IsZero() {
+				atime = fi.ModTime()
+			}
+			if mtime.IsZero() {
+				mtime = fi.ModTime()
+			}
+		}
+		if w.dir != nil && !w.anonymous {
+			if err := chtimesAt(w.dir, w.tmpName, atime, mtime); err != nil {
+				return err
+			}
+		} else if err := os.Chtimes(tempPath(w.f, w.anonymous), atime, mtime); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // WriteSet is used to atomically write a set
 // of files and ensure they are visible at the same time.
-// Must be committed to a new directory.
+// Must be committed to a new directory, or to an existing
+// directory using [WriteSet.CommitReplace].
 type WriteSet struct {
 	root string
 }
@@ -170,7 +561,7 @@ type WriteSet struct {
 // commit. If no temporary directory is given the system
 // default is used.
 func NewWriteSet(tmpDir string) (*WriteSet, error) {
-	td, err := os.MkdirTemp(tmpDir, "write-set-")
+	td, err := os.MkdirTemp(tmpDir, writeSetPrefix+"*"+tempSuffix)
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +603,12 @@ func (w syncFileCloser) Close() error {
 // FileWriter opens a file writer inside the set. The file
 // should be synced and closed before calling commit.
 //
+// The name must be relative to the set, and must not refer to a location
+// outside of the set, or a [*PathEscapeError] is returned. Symlinks inside
+// the set are resolved as if the set was the root of the filesystem, so
+// that they cannot redirect writes to outside of the set. The same applies
+// to all other [WriteSet] operations that accept a name.
+//
 // FileWriter uses [sequential.OpenFile] to use sequential file access on Windows,
 // avoiding depleting the standby list un-necessarily. On Linux, this equates to
 // a regular [os.OpenFile]. Refer to the [Win32 API documentation] for details
@@ -219,13 +616,120 @@ func (w syncFileCloser) Close() error {
 //
 // [Win32 API documentation]: https://learn.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-createfilea#FILE_FLAG_SEQUENTIAL_SCAN
 func (ws *WriteSet) FileWriter(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
-	f, err := sequential.OpenFile(filepath.Join(ws.root, name), flag, perm)
+	p, err := ws.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := sequential.OpenFile(p, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 	return syncFileCloser{f}, nil
 }
 
+// Mkdir creates a directory inside the set, similar to [os.Mkdir].
+func (ws *WriteSet) Mkdir(name string, perm os.FileMode) error {
+	p, err := ws.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, perm)
+}
+
+// MkdirAll creates a directory inside the set, along with any necessary
+// parents, similar to [os.MkdirAll].
+func (ws *WriteSet) MkdirAll(name string, perm os.FileMode) error {
+	p, err := ws.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// Symlink creates newname inside the set as a symbolic link to oldname,
+// similar to [os.Symlink]. The link target (oldname) is stored as-is, and
+// is not required to be inside the set; for example, a symlink to an
+// absolute path is resolved relative to the root of the filesystem once
+// the set is committed.
+func (ws *WriteSet) Symlink(oldname, newname string) error {
+	p, err := ws.path(newname)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(oldname, p)
+}
+
+// Link creates newname inside the set as a hard link to the file oldname,
+// which must also be inside the set, similar to [os.Link]. If oldname is a
+// symlink, it is resolved within the set, and newname is a hard link to its
+// target; link(2) follows symlinks on some platforms, so the symlink itself
+// is never linked.
+func (ws *WriteSet) Link(oldname, newname string) error {
+	oldpath, err := ws.resolve(oldname)
+	if err != nil {
+		return err
+	}
+	newpath, err := ws.path(newname)
+	if err != nil {
+		return err
+	}
+	return os.Link(oldpath, newpath)
+}
+
+// path returns the location of name inside the set, to create name. Any
+// symlinks in the parent directories of name are resolved within the set, so
+// that a symlink inside the set cannot redirect the location to outside of
+// the set. The last element of name is not resolved.
+func (ws *WriteSet) path(name string) (string, error) {
+	clean, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	dir, err := symlink.FollowSymlinkInScope(filepath.Join(ws.root, filepath.Dir(clean)), ws.root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(clean)), nil
+}
+
+// resolve is like [WriteSet.path], but also resolves the last element of name
+// within the set.
+func (ws *WriteSet) resolve(name string) (string, error) {
+	clean, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return symlink.FollowSymlinkInScope(filepath.Join(ws.root, clean), ws.root)
+}
+
+// cleanName returns the shortest equivalent of name, which must be relative
+// and not refer to a location outside of the set.
+func cleanName(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("file name is empty")
+	}
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &PathEscapeError{Name: name}
+	}
+	clean := filepath.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", &PathEscapeError{Name: name}
+	}
+	return clean, nil
+}
+
+// PathEscapeError is returned by [WriteSet] and [Dir] operations if a name is
+// absolute, refers to the root of the set or directory, or refers to a
+// location outside of it.
+type PathEscapeError struct {
+	// Name is the name that was rejected.
+	Name string
+}
+
+func (e *PathEscapeError) Error() string {
+	return "invalid name " + strconv.Quote(e.Name) + ": path escapes from root"
+}
+
 // Cancel cancels the set and removes all temporary data
 // created in the set.
 func (ws *WriteSet) Cancel() error {
@@ -235,8 +739,108 @@ func (ws *WriteSet) Cancel() error {
 // Commit moves all created files to the target directory. The
 // target directory must not exist and the parent of the target
 // directory must exist.
-func (ws *WriteSet) Commit(target string) error {
-	return os.Rename(ws.root, target)
+//
+// With [WithSyncDir], the set and all directories in it are synced before it
+// is moved, and the parent of the target directory is synced after it has
+// been moved.
+func (ws *WriteSet) Commit(target string, opts ...Option) error {
+	o := applyOptions(opts)
+	if o.syncDir {
+		if err := syncTree(ws.root); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(ws.root, target); err != nil {
+		return err
+	}
+	if o.syncDir {
+		dir := filepath.Dir(target)
+		if err := syncDir(dir); err != nil {
+			return &SyncDirError{Dir: dir, Err: err}
+		}
+	}
+	return nil
+}
+
+// syncTree syncs dir and all directories below it, so that entries created
+// in subdirectories of a set are durable as well. Symlinks are not followed.
+func syncTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return syncDir(path)
+	})
+}
+
+// CommitReplace moves all created files to the target directory, replacing
+// the target directory and its contents if it already exists. The parent of
+// the target directory must exist. If the target directory does not exist,
+// CommitReplace is equivalent to [WriteSet.Commit].
+//
+// On Linux, the set and the target directory are swapped atomically using
+// renameat2(2) with RENAME_EXCHANGE, after which the previous contents of the
+// target directory are removed. If exchanging is not supported by the kernel
+// or filesystem (and on other platforms), the target directory is first moved
+// aside to a temporary name in the same parent directory, and the set is then
+// moved into place. In that case, the target directory is briefly missing.
+//
+// The previous contents are removed after the new contents have been moved
+// into place; an error removing them is returned, but does not undo the commit.
+//
+// With [WithSyncDir], the set and all directories in it are synced before it
+// is moved, and the parent of the target directory is synced after it has
+// been moved.
+func (ws *WriteSet) CommitReplace(target string, opts ...Option) error {
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return ws.Commit(target, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "commit", Path: target, Err: syscall.ENOTDIR}
+	}
+
+	o := applyOptions(opts)
+	if o.syncDir {
+		if err := syncTree(ws.root); err != nil {
+			return err
+		}
+	}
+
+	var old string
+	err = exchange(ws.root, target)
+	switch {
+	case err == nil:
+		// The set now holds the previous contents of the target.
+		old = ws.root
+	case errors.Is(err, errExchangeNotSupported):
+		old = filepath.Join(filepath.Dir(target), tempName(oldDirPrefix, filepath.Base(target)))
+		if err := os.Rename(target, old); err != nil {
+			return err
+		}
+		if err := os.Rename(ws.root, target); err != nil {
+			// Put the previous contents back in place.
+			_ = os.Rename(old, target)
+			return err
+		}
+	default:
+		return err
+	}
+
+	var syncErr error
+	if o.syncDir {
+		dir := filepath.Dir(target)
+		if err := syncDir(dir); err != nil {
+			syncErr = &SyncDirError{Dir: dir, Err: err}
+		}
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("failed to remove previous contents of %s: %w", target, err)
+	}
+	return syncErr
 }
 
 // String returns the location the set is writing to.
@@ -0,0 +1,393 @@
+package atomicwriter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewWithOptionsChown(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	if err := WriteFile(fileName, []byte("file content"), testMode(), WithChown(1234, 5678)); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	st, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Error statting file: %v", err)
+	}
+	if sys := st.Sys().(*syscall.Stat_t); sys.Uid != 1234 || sys.Gid != 5678 {
+		t.Errorf("Expected owner 1234:5678, got %d:%d", sys.Uid, sys.Gid)
+	}
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestNewWithOptionsXattrs(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	err := WriteFile(fileName, []byte("file content"), testMode(), WithXattrs(map[string][]byte{
+		"user.foo": []byte("bar"),
+	}))
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("user xattrs are not supported by the filesystem")
+	}
+	if err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := unix.Getxattr(fileName, "user.foo", buf)
+	if err != nil {
+		t.Fatalf("Error getting xattr: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("bar")) {
+		t.Errorf("Expected xattr value %q, got %q", "bar", buf[:n])
+	}
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestWriteFilePreserveOwnerAndXattrs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(fileName, 1234, 5678); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(fileName, "user.foo", []byte("foo"), 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			t.Skip("user xattrs are not supported by the filesystem")
+		}
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(fileName, "user.bar", []byte("bar"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteFile(fileName, []byte("new content"), 0o600, WithPreserve(), WithXattrs(map[string][]byte{
+		"user.bar": []byte("baz"),
+	}))
+	if err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	assertFile(t, fileName, []byte("new content"), testMode())
+	st, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Error statting file: %v", err)
+	}
+	if sys := st.Sys().(*syscall.Stat_t); sys.Uid != 1234 || sys.Gid != 5678 {
+		t.Errorf("Expected owner 1234:5678, got %d:%d", sys.Uid, sys.Gid)
+	}
+	for name, expected := range map[string]string{"user.foo": "foo", "user.bar": "baz"} {
+		buf := make([]byte, 16)
+		n, err := unix.Getxattr(fileName, name, buf)
+		if err != nil {
+			t.Errorf("Error getting xattr %s: %v", name, err)
+			continue
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("Expected xattr %s to be %q, got %q", name, expected, buf[:n])
+		}
+	}
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestUmask(t *testing.T) {
+	// Changing the umask is picked up from /proc, without changing
+	// the umask determined at initialization.
+	old := syscall.Umask(0o027)
+	defer syscall.Umask(old)
+	if m := umask(); m != 0o027 {
+		t.Errorf("Expected umask 027, got %03o", m)
+	}
+	if startUmask != os.FileMode(old) {
+		t.Errorf("Expected initial umask %03o, got %03o", old, startUmask)
+	}
+}
+
+func TestNewAnonymousTempFile(t *testing.T) {
+	for _, tc := range []string{"new-file", "existing-file"} {
+		t.Run(tc, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			fileName := filepath.Join(tmpDir, "test.txt")
+			var origFileCount int
+			if tc == "existing-file" {
+				if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+					t.Fatalf("Error writing file: %v", err)
+				}
+				origFileCount = 1
+			}
+			writer, err := New(fileName, testMode())
+			if err != nil {
+				t.Fatalf("Error creating new atomicwriter: %v", err)
+			}
+			if !writer.(*Writer).anonymous {
+				t.Skip("O_TMPFILE is not supported by the filesystem")
+			}
+			fileContent := []byte("new content")
+			if _, err := writer.Write(fileContent); err != nil {
+				t.Fatalf("Error writing to file: %v", err)
+			}
+			assertFileCount(t, tmpDir, origFileCount)
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Error closing writer: %v", err)
+			}
+			assertFile(t, fileName, fileContent, testMode())
+			assertFileCount(t, tmpDir, 1)
+		})
+	}
+}
+
+func TestCreateTempFallback(t *testing.T) {
+	// O_TMPFILE is not supported on non-directories (and on some filesystems);
+	// createTemp must fall back to a named temporary file, and fail the same
+	// way as os.CreateTemp if that is not possible either.
+	tmpDir := t.TempDir()
+	f, anonymous, err := createTemp(filepath.Join(tmpDir, "missing"), ".tmp-test.txt")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Should produce a 'not found' error, but got %[1]T (%[1]v)", err)
+	}
+	if f != nil || anonymous {
+		t.Errorf("Should not have created a file")
+	}
+}
+
+func TestDirWriteFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a/b", filepath.Join(tmpDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Error opening directory: %v", err)
+	}
+	defer d.Close()
+
+	for _, name := range []string{"test.txt", "a/b/test.txt", "link/other.txt"} {
+		if err := d.WriteFile(name, []byte("file content"), testMode(), WithSyncDir()); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+		if err := d.WriteFile(name, []byte("new content"), testMode()); err != nil {
+			t.Fatalf("Error replacing %s: %v", name, err)
+		}
+		assertFile(t, filepath.Join(tmpDir, name), []byte("new content"), testMode())
+	}
+	assertFileCount(t, filepath.Join(tmpDir, "a", "b"), 2)
+
+	if err := d.WriteFile("link", []byte("file content"), testMode()); err == nil {
+		t.Errorf("Should not be able to write to a symlink")
+	}
+	if err := d.WriteFile("a", []byte("file content"), testMode()); err == nil {
+		t.Errorf("Should not be able to write to a directory")
+	}
+	if err := d.WriteFile("test.txt", nil, testMode(), WithPreserve()); err == nil {
+		t.Errorf("Should not accept WithPreserve")
+	}
+}
+
+func TestDirNamedTemp(t *testing.T) {
+	procSelfFD()
+	procSelfFDOK = false
+	defer func() { procSelfFDOK = true }()
+
+	tmpDir := t.TempDir()
+	d, err := OpenDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Error opening directory: %v", err)
+	}
+	defer d.Close()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := d.NewWriter("test.txt", WithMode(testMode()), WithTimes(mtime, mtime))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	if w.anonymous {
+		t.Fatal("Expected a named temporary file")
+	}
+	assertFileCount(t, tmpDir, 1)
+	if err := writeAndCommit(w, []byte("file content")); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	assertFile(t, filepath.Join(tmpDir, "test.txt"), []byte("file content"), testMode())
+	assertFileCount(t, tmpDir, 1)
+	fi, err := os.Stat(filepath.Join(tmpDir, "test.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, fi.ModTime())
+	}
+}
+
+func TestWriteFileBackupRenameError(t *testing.T) {
+	procSelfFD()
+	procSelfFDOK = false
+	defer func() { procSelfFDOK = true }()
+
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	for _, content := range []string{"v1", "v2", "v3"} {
+		if err := WriteFile(fileName, []byte(content), testMode(), WithBackupRotation(2)); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+	}
+
+	// Make the rename fail by removing the named temporary file.
+	w, err := NewWriter(fileName, WithMode(testMode()), WithBackupRotation(2))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	if w.anonymous {
+		t.Fatal("Expected a named temporary file")
+	}
+	if _, err := w.Write([]byte("v4")); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := os.Remove(w.f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected %v, got %v", os.ErrNotExist, err)
+	}
+
+	assertFile(t, fileName, []byte("v3"), testMode())
+	assertFile(t, fileName+".1", []byte("v2"), testMode())
+	assertFile(t, fileName+".2", []byte("v1"), testMode())
+	assertFileCount(t, tmpDir, 3)
+}
+
+func TestDirEscape(t *testing.T) {
+	tmpDir := t.TempDir()
+	root := filepath.Join(tmpDir, "root")
+	outside := filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../outside", filepath.Join(root, "relative")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "absolute")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDir(root)
+	if err != nil {
+		t.Fatalf("Error opening directory: %v", err)
+	}
+	defer d.Close()
+
+	for _, name := range []string{"", ".", "..", "../outside/test.txt", "/test.txt", "relative/test.txt", "absolute/test.txt"} {
+		if err := d.WriteFile(name, []byte("file content"), testMode()); err == nil {
+			t.Errorf("Should not be able to write %q", name)
+		}
+	}
+	assertFileCount(t, outside, 0)
+
+	// Without openat2, symlinks in parent directories are rejected.
+	if _, err := d.walkParent("relative"); !errors.Is(err, unix.ELOOP) && !errors.Is(err, unix.ENOTDIR) {
+		t.Errorf("Expected ELOOP or ENOTDIR, got %v", err)
+	}
+}
+
+func TestWithPreallocate(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	const size = 1 << 20
+	w, err := NewWriter(fileName, WithMode(testMode()), WithPreallocate(size))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	var st unix.Stat_t
+	if err := unix.Fstat(int(w.f.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Size != 0 {
+		t.Errorf("Preallocating should not change the size, got %d", st.Size)
+	}
+	if st.Blocks*512 < size {
+		t.Skipf("Preallocating is not supported by the filesystem (%d blocks)", st.Blocks)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	assertFile(t, fileName, []byte{}, testMode())
+
+	// Preallocating more space than available fails early.
+	_, err = NewWriter(fileName, WithPreallocate(1<<62))
+	if !errors.Is(err, unix.ENOSPC) && !errors.Is(err, unix.EFBIG) {
+		t.Errorf("Expected ENOSPC or EFBIG, got %v", err)
+	}
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestWriterCopySparseHoles(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	const size = 16 << 20
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("data"), size/2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(tmpDir, "test.txt")
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	if _, err := w.CopySparse(f, size); err != nil {
+		t.Fatalf("Error copying: %v", err)
+	}
+	if off, err := f.Seek(0, io.SeekCurrent); err != nil || off != 0 {
+		t.Errorf("Offset of source should not change, got %d (%v)", off, err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(fileName, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Size != size {
+		t.Errorf("Expected size %d, got %d", size, st.Size)
+	}
+	if st.Blocks*512 >= size/2 {
+		t.Errorf("Expected a sparse file, got %d blocks", st.Blocks)
+	}
+	actual, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, size)
+	copy(expected[size/2:], "data")
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Data mismatch")
+	}
+}
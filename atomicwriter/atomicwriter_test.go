@@ -2,13 +2,21 @@ package atomicwriter
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // testMode returns the file-mode to use in tests, accounting for Windows
@@ -89,9 +97,14 @@ func TestNew(t *testing.T) {
 					if err != nil {
 						t.Fatalf("Error creating new atomicwriter: %v", err)
 					}
-					files := assertFileCount(t, actualParentDir, origFileCount+1)
-					if tmpFileName := files[0].Name(); !strings.HasPrefix(tmpFileName, ".tmp-test.txt") {
-						t.Errorf("Unexpected file name for temp-file: %s", tmpFileName)
+					if writer.(*Writer).anonymous {
+						// Anonymous (O_TMPFILE) temp-files are not visible in the directory.
+						assertFileCount(t, actualParentDir, origFileCount)
+					} else {
+						files := assertFileCount(t, actualParentDir, origFileCount+1)
+						if tmpFileName := files[0].Name(); !strings.HasPrefix(tmpFileName, ".tmp-test.txt") {
+							t.Errorf("Unexpected file name for temp-file: %s", tmpFileName)
+						}
 					}
 
 					// Closing the writer without writing should clean up the temp-file,
@@ -323,3 +336,939 @@ func TestWriteSetCancel(t *testing.T) {
 	}
 	assertFileCount(t, filepath.Join(tmpDir, "tmp"), 0)
 }
+
+func TestWriteFileSyncDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	fileContent := []byte("file content")
+	fileMode := testMode()
+	if err := WriteFile(fileName, fileContent, fileMode, WithSyncDir()); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	assertFile(t, fileName, fileContent, fileMode)
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestWriteSetCommitSyncDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+
+	fileContent := []byte("file content")
+	fileMode := testMode()
+	if err := ws.WriteFile("foo", fileContent, fileMode); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := ws.MkdirAll("sub/dir", 0o755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := ws.WriteFile("sub/dir/bar", fileContent, fileMode); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := ws.Symlink("dir/bar", "sub/bar"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+
+	targetDir := filepath.Join(tmpDir, "target")
+	if err := ws.Commit(targetDir, WithSyncDir()); err != nil {
+		t.Fatalf("Error committing file: %s", err)
+	}
+
+	assertFile(t, filepath.Join(targetDir, "foo"), fileContent, fileMode)
+	assertFile(t, filepath.Join(targetDir, "sub", "dir", "bar"), fileContent, fileMode)
+	assertFile(t, filepath.Join(targetDir, "sub", "bar"), fileContent, fileMode)
+	assertFileCount(t, tmpDir, 1)
+}
+
+func TestSyncDirError(t *testing.T) {
+	err := error(&SyncDirError{Dir: "/some/dir", Err: syscall.EIO})
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("Expected error to wrap EIO, got %v", err)
+	}
+	var syncErr *SyncDirError
+	if !errors.As(err, &syncErr) || syncErr.Dir != "/some/dir" {
+		t.Errorf("Expected a SyncDirError for /some/dir, got %v", err)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	t.Run("default mode", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not supported on Windows")
+		}
+		tmpDir := t.TempDir()
+		fileName := filepath.Join(tmpDir, "test.txt")
+		fileContent := []byte("file content")
+		writer, err := NewWithOptions(fileName)
+		if err != nil {
+			t.Fatalf("Error creating new atomicwriter: %v", err)
+		}
+		if _, err := writer.Write(fileContent); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Error closing writer: %v", err)
+		}
+		assertFile(t, fileName, fileContent, 0o600)
+		assertFileCount(t, tmpDir, 1)
+	})
+	t.Run("umask", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("umask is not supported on Windows")
+		}
+		tmpDir := t.TempDir()
+		fileName := filepath.Join(tmpDir, "test.txt")
+		fileContent := []byte("file content")
+		if err := WriteFile(fileName, fileContent, 0o666, WithUmask()); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, fileName, fileContent, 0o666&^umask())
+	})
+	t.Run("times", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		fileName := filepath.Join(tmpDir, "test.txt")
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := WriteFile(fileName, []byte("file content"), testMode(), WithTimes(time.Time{}, mtime)); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		st, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatalf("Error statting file: %v", err)
+		}
+		if !st.ModTime().Equal(mtime) {
+			t.Errorf("Expected mtime %v, got %v", mtime, st.ModTime())
+		}
+	})
+}
+
+func TestWriteFileFollowSymlinks(t *testing.T) {
+	t.Run("symlinked file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		linkTarget := filepath.Join(tmpDir, "symlink-target")
+		if err := os.WriteFile(linkTarget, []byte("original content"), testMode()); err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(tmpDir, "symlinked-file")
+		if err := os.Symlink(linkTarget, fileName); err != nil {
+			t.Fatal(err)
+		}
+
+		fileContent := []byte("new content")
+		fileMode := testMode()
+		if err := WriteFile(fileName, fileContent, fileMode, WithFollowSymlinks("")); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, linkTarget, fileContent, fileMode)
+		if fi, err := os.Lstat(fileName); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected %s to still be a symlink: %v", fileName, err)
+		}
+		assertFileCount(t, tmpDir, 2)
+	})
+	t.Run("dangling symlink", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		linkTarget := filepath.Join(tmpDir, "symlink-target")
+		fileName := filepath.Join(tmpDir, "symlinked-file")
+		if err := os.Symlink(linkTarget, fileName); err != nil {
+			t.Fatal(err)
+		}
+
+		fileContent := []byte("new content")
+		fileMode := testMode()
+		if err := WriteFile(fileName, fileContent, fileMode, WithFollowSymlinks("")); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, linkTarget, fileContent, fileMode)
+		assertFileCount(t, tmpDir, 2)
+	})
+	t.Run("scoped", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("absolute symlinks within a scope are not tested on Windows")
+		}
+		tmpDir := t.TempDir()
+		rootDir := filepath.Join(tmpDir, "rootfs")
+		if err := os.MkdirAll(filepath.Join(rootDir, "etc"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		// An absolute symlink that would escape the root if resolved on the host.
+		fileName := filepath.Join(rootDir, "etc", "resolv.conf")
+		if err := os.Symlink("/resolv.conf", fileName); err != nil {
+			t.Fatal(err)
+		}
+
+		fileContent := []byte("nameserver 127.0.0.1\n")
+		fileMode := testMode()
+		if err := WriteFile(fileName, fileContent, fileMode, WithFollowSymlinks(rootDir)); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, filepath.Join(rootDir, "resolv.conf"), fileContent, fileMode)
+		assertFileCount(t, tmpDir, 1)
+	})
+}
+
+func TestWriteFilePreserve(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+	t.Run("existing file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		fileName := filepath.Join(tmpDir, "test.txt")
+		if err := os.WriteFile(fileName, []byte("original content"), 0o604); err != nil {
+			t.Fatal(err)
+		}
+		// Make sure the mode is not affected by umask.
+		if err := os.Chmod(fileName, 0o604); err != nil {
+			t.Fatal(err)
+		}
+		fileContent := []byte("new content")
+		if err := WriteFile(fileName, fileContent, 0o600, WithPreserve()); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, fileName, fileContent, 0o604)
+		assertFileCount(t, tmpDir, 1)
+	})
+	t.Run("new file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		fileName := filepath.Join(tmpDir, "test.txt")
+		fileContent := []byte("new content")
+		if err := WriteFile(fileName, fileContent, 0o640, WithPreserve()); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+		assertFile(t, fileName, fileContent, 0o640)
+		assertFileCount(t, tmpDir, 1)
+	})
+}
+
+func TestWriteSetCommitReplace(t *testing.T) {
+	for _, tc := range []string{"new-target", "existing-target"} {
+		t.Run(tc, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(tmpDir, "tmp"), 0o700); err != nil {
+				t.Fatalf("Error creating tmp directory: %s", err)
+			}
+			targetDir := filepath.Join(tmpDir, "target")
+			if tc == "existing-target" {
+				if err := os.Mkdir(targetDir, 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(targetDir, "old"), []byte("old content"), testMode()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ws, err := NewWriteSet(filepath.Join(tmpDir, "tmp"))
+			if err != nil {
+				t.Fatalf("Error creating atomic write set: %s", err)
+			}
+			fileContent := []byte("file content")
+			fileMode := testMode()
+			if err := ws.WriteFile("foo", fileContent, fileMode); err != nil {
+				t.Fatalf("Error writing to file: %v", err)
+			}
+			if err := ws.CommitReplace(targetDir, WithSyncDir()); err != nil {
+				t.Fatalf("Error committing file: %s", err)
+			}
+
+			assertFile(t, filepath.Join(targetDir, "foo"), fileContent, fileMode)
+			assertFileCount(t, targetDir, 1)
+			assertFileCount(t, filepath.Join(tmpDir, "tmp"), 0)
+			assertFileCount(t, tmpDir, 2)
+		})
+	}
+	t.Run("target is not a directory", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		targetFile := filepath.Join(tmpDir, "target")
+		if err := os.WriteFile(targetFile, []byte("original content"), testMode()); err != nil {
+			t.Fatal(err)
+		}
+		ws, err := NewWriteSet(tmpDir)
+		if err != nil {
+			t.Fatalf("Error creating atomic write set: %s", err)
+		}
+		defer ws.Cancel()
+		if err := ws.CommitReplace(targetFile); !errors.Is(err, syscall.ENOTDIR) {
+			t.Errorf("Should produce a 'not a directory' error, but got %[1]T (%[1]v)", err)
+		}
+		assertFile(t, targetFile, []byte("original content"), testMode())
+	})
+}
+
+func TestWriteSetTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+
+	fileContent := []byte("file content")
+	fileMode := testMode()
+	if err := ws.MkdirAll("rootfs/etc", 0o755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := ws.Mkdir("rootfs/run", 0o755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := ws.WriteFile("config.json", fileContent, fileMode); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := ws.WriteFile("rootfs/run/resolv.conf", fileContent, fileMode); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := ws.Symlink("../run/resolv.conf", "rootfs/etc/resolv.conf"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := ws.Link("config.json", "rootfs/config.json"); err != nil {
+		t.Fatalf("Error creating hard link: %v", err)
+	}
+
+	targetDir := filepath.Join(tmpDir, "target")
+	if err := ws.Commit(targetDir); err != nil {
+		t.Fatalf("Error committing file: %s", err)
+	}
+	assertFile(t, filepath.Join(targetDir, "config.json"), fileContent, fileMode)
+	assertFile(t, filepath.Join(targetDir, "rootfs", "config.json"), fileContent, fileMode)
+	assertFile(t, filepath.Join(targetDir, "rootfs", "etc", "resolv.conf"), fileContent, fileMode)
+	if target, err := os.Readlink(filepath.Join(targetDir, "rootfs", "etc", "resolv.conf")); err != nil {
+		t.Errorf("Error reading symlink: %v", err)
+	} else if target != "../run/resolv.conf" {
+		t.Errorf("Expected symlink to point to %q, got %q", "../run/resolv.conf", target)
+	}
+}
+
+func TestWriteSetInvalidNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	defer ws.Cancel()
+
+	if err := ws.WriteFile("", []byte("content"), testMode()); err == nil || err.Error() != "file name is empty" {
+		t.Errorf("Should produce a 'file name is empty' error, but got %[1]T (%[1]v)", err)
+	}
+	for _, name := range []string{".", "..", "../foo", "../../etc/passwd", "foo/../../bar", filepath.Join(tmpDir, "foo")} {
+		var escapeErr *PathEscapeError
+		if err := ws.WriteFile(name, []byte("content"), testMode()); !errors.As(err, &escapeErr) {
+			t.Errorf("WriteFile(%q): expected a PathEscapeError, got %[2]T (%[2]v)", name, err)
+		}
+		if err := ws.Mkdir(name, 0o755); !errors.As(err, &escapeErr) {
+			t.Errorf("Mkdir(%q): expected a PathEscapeError, got %[2]T (%[2]v)", name, err)
+		}
+		if err := ws.Symlink("foo", name); !errors.As(err, &escapeErr) {
+			t.Errorf("Symlink(%q): expected a PathEscapeError, got %[2]T (%[2]v)", name, err)
+		}
+		if err := ws.Link("foo", name); !errors.As(err, &escapeErr) {
+			t.Errorf("Link(%q): expected a PathEscapeError, got %[2]T (%[2]v)", name, err)
+		}
+	}
+	assertFileCount(t, tmpDir, 1)
+	assertFileCount(t, ws.String(), 0)
+}
+
+func TestWriteSetSymlinkEscape(t *testing.T) {
+	tmpDir := t.TempDir()
+	outsideDir := filepath.Join(tmpDir, "outside")
+	if err := os.Mkdir(outsideDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	defer ws.Cancel()
+
+	// Symlinks pointing outside of the set must be resolved within the set;
+	// "../outside" resolves to "outside" inside the set.
+	if err := ws.Mkdir("outside", 0o700); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := ws.Symlink("../outside", "dir-link"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := ws.Symlink("../outside/file", "file-link"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := ws.WriteFile("dir-link/other-file", []byte("content"), testMode()); err != nil {
+		t.Errorf("Error writing to file: %v", err)
+	}
+	if err := ws.WriteFile("file-link", []byte("content"), testMode()); err != nil {
+		t.Errorf("Error writing to file: %v", err)
+	}
+	if err := ws.Mkdir("dir-link/dir", 0o700); err != nil {
+		t.Errorf("Error creating directory: %v", err)
+	}
+	assertFileCount(t, outsideDir, 0)
+	assertFileCount(t, filepath.Join(ws.String(), "outside"), 3)
+}
+
+func TestWriteSetLinkSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	outsideFile := filepath.Join(tmpDir, "outside")
+	outsideContent := []byte("outside content")
+	if err := os.WriteFile(outsideFile, outsideContent, testMode()); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	defer ws.Cancel()
+
+	// A symlink used as the source of a hard link must be resolved within
+	// the set, and not link to the file outside of the set.
+	if err := ws.Symlink(outsideFile, "outside-link"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := ws.Link("outside-link", "outside-hardlink"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+	if err := ws.WriteFile("outside-hardlink", []byte("content"), testMode()); err != nil {
+		t.Errorf("Error writing to file: %v", err)
+	}
+	assertFile(t, outsideFile, outsideContent, testMode())
+
+	// A symlink to a file inside the set links to that file.
+	fileContent := []byte("file content")
+	if err := ws.WriteFile("file", fileContent, testMode()); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := ws.Symlink("file", "file-link"); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+	if err := ws.Link("file-link", "file-hardlink"); err != nil {
+		t.Fatalf("Error creating hard link: %v", err)
+	}
+	fi1, err := os.Stat(filepath.Join(ws.String(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi2, err := os.Lstat(filepath.Join(ws.String(), "file-hardlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi1, fi2) {
+		t.Error("Expected the hard link to refer to the symlink target")
+	}
+}
+
+func TestCleanupStale(t *testing.T) {
+	tmpDir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+
+	// An abandoned write set.
+	ws, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	if err := ws.WriteFile("foo", []byte("content"), testMode()); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	for _, p := range []string{filepath.Join(ws.String(), "foo"), ws.String()} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A write set that is still in use.
+	ws2, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	defer ws2.Cancel()
+
+	// A write set that was created long ago, but is still being written
+	// to; writing files does not update the modification time of the
+	// directory.
+	ws3, err := NewWriteSet(tmpDir)
+	if err != nil {
+		t.Fatalf("Error creating atomic write set: %s", err)
+	}
+	defer ws3.Cancel()
+	if err := ws3.WriteFile("foo", []byte("content"), testMode()); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	if err := os.Chtimes(ws3.String(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write sets created by earlier versions.
+	for name, modTime := range map[string]time.Time{
+		"write-set-1234567890": old,
+		"write-set-987654321":  time.Now(),
+		"write-set-unrelated":  old,
+	} {
+		dirName := filepath.Join(tmpDir, name)
+		if err := os.Mkdir(dirName, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(dirName, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, modTime := range map[string]time.Time{
+		tempName(tempFilePrefix, "stale.txt"): old,
+		tempName(oldDirPrefix, "stale-dir"):   old,
+		tempName(tempFilePrefix, "fresh.txt"): time.Now(),
+		".tmp-legacy.txt1234567890":           old,
+		".tmp-legacy-fresh.txt1234567890":     time.Now(),
+		".tmp-unrelated.txt":                  old,
+		"regular.txt":                         old,
+	} {
+		fileName := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(fileName, []byte("content"), testMode()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertFileCount(t, tmpDir, 13)
+
+	if err := CleanupStale(tmpDir, time.Hour); err != nil {
+		t.Fatalf("Error cleaning up: %v", err)
+	}
+
+	files := assertFileCount(t, tmpDir, 8)
+	for _, f := range files {
+		switch name := f.Name(); {
+		case name == filepath.Base(ws2.String()),
+			name == filepath.Base(ws3.String()),
+			name == "write-set-987654321",
+			name == "write-set-unrelated",
+			strings.HasPrefix(name, ".tmp-fresh.txt-"),
+			name == ".tmp-legacy-fresh.txt1234567890",
+			name == ".tmp-unrelated.txt",
+			name == "regular.txt":
+		default:
+			t.Errorf("Unexpected file after cleanup: %s", name)
+		}
+	}
+}
+
+func TestWriteFileVerify(t *testing.T) {
+	fileContent := []byte("file content")
+	sha256Digest := sha256.Sum256(fileContent)
+	sha512Digest := sha512.Sum512(fileContent)
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		mismatch string // "digest" or "size" if a mismatch is expected
+	}{
+		{name: "sha256", opts: []Option{WithExpectedDigest(sha256.New(), sha256Digest[:])}},
+		{name: "sha512 and size", opts: []Option{WithExpectedDigest(sha512.New(), sha512Digest[:]), WithExpectedSize(int64(len(fileContent)))}},
+		{name: "digest mismatch", opts: []Option{WithExpectedDigest(sha512.New(), sha256Digest[:])}, mismatch: "digest"},
+		{name: "empty digest", opts: []Option{WithExpectedDigest(sha256.New(), nil)}, mismatch: "digest"},
+		{name: "size mismatch", opts: []Option{WithExpectedDigest(sha256.New(), sha256Digest[:]), WithExpectedSize(1)}, mismatch: "size"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			fileName := filepath.Join(tmpDir, "test.txt")
+			if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+				t.Fatal(err)
+			}
+			err := WriteFile(fileName, fileContent, testMode(), tc.opts...)
+			if tc.mismatch == "" {
+				if err != nil {
+					t.Fatalf("Error writing to file: %v", err)
+				}
+				assertFile(t, fileName, fileContent, testMode())
+				return
+			}
+			var mismatchErr *MismatchError
+			if !errors.As(err, &mismatchErr) {
+				t.Fatalf("Expected a MismatchError, got %[1]T (%[1]v)", err)
+			}
+			if mismatchErr.Size != int64(len(fileContent)) {
+				t.Errorf("Expected size %d, got %d", len(fileContent), mismatchErr.Size)
+			}
+			if mismatchErr.DigestMismatch != (tc.mismatch == "digest") {
+				t.Errorf("Expected a %s mismatch, got DigestMismatch=%v", tc.mismatch, mismatchErr.DigestMismatch)
+			}
+			if msg := err.Error(); !strings.HasPrefix(msg, tc.mismatch+" mismatch") {
+				t.Errorf("Expected a %s mismatch error, got %q", tc.mismatch, msg)
+			}
+			assertFile(t, fileName, []byte("original content"), testMode())
+			assertFileCount(t, tmpDir, 1)
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "counter")
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Update(fileName, testMode(), func(old []byte) ([]byte, error) {
+				var n int
+				if old != nil {
+					var err error
+					if n, err = strconv.Atoi(string(old)); err != nil {
+						return nil, err
+					}
+				}
+				return []byte(strconv.Itoa(n + 1)), nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Error updating file: %v", err)
+		}
+	}
+	assertFile(t, fileName, []byte(strconv.Itoa(workers)), testMode())
+	assertFileCount(t, tmpDir, 2)
+}
+
+func TestUpdateError(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+		t.Fatal(err)
+	}
+	expectedErr := errors.New("update failed")
+	err := Update(fileName, testMode(), func(old []byte) ([]byte, error) {
+		if !bytes.Equal(old, []byte("original content")) {
+			t.Errorf("Expected old content %q, got %q", "original content", old)
+		}
+		return []byte("new content"), expectedErr
+	})
+	if !errors.Is(err, expectedErr) {
+		t.Errorf("Expected %v, got %v", expectedErr, err)
+	}
+	assertFile(t, fileName, []byte("original content"), testMode())
+}
+
+func TestUpdateContextTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	lock, err := lockFile(context.Background(), fileName+".lock", testMode())
+	if err != nil {
+		t.Fatalf("Error locking file: %v", err)
+	}
+	defer lock.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = UpdateContext(ctx, fileName, testMode(), func([]byte) ([]byte, error) {
+		t.Error("Update function should not be called without holding the lock")
+		return nil, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if _, err := os.Stat(fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("File should not have been created: %v", err)
+	}
+}
+
+func TestWriteFileBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+
+	// No backup is made if the destination does not exist.
+	if err := WriteFile(fileName, []byte("v1"), testMode(), WithBackup()); err != nil {
+		t.Fatalf("Error writing to file: %v", err)
+	}
+	assertFileCount(t, tmpDir, 1)
+
+	for _, content := range []string{"v2", "v3"} {
+		if err := WriteFile(fileName, []byte(content), testMode(), WithBackup()); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+	}
+	assertFile(t, fileName, []byte("v3"), testMode())
+	assertFile(t, fileName+".bak", []byte("v2"), testMode())
+	assertFileCount(t, tmpDir, 2)
+
+	// The backup is left unchanged if the write is not committed.
+	err := WriteFile(fileName, []byte("v4"), testMode(), WithBackup(), WithExpectedSize(1))
+	var mismatchErr *MismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("Expected a MismatchError, got %[1]T (%[1]v)", err)
+	}
+	assertFile(t, fileName, []byte("v3"), testMode())
+	assertFile(t, fileName+".bak", []byte("v2"), testMode())
+}
+
+func TestWriteFileBackupRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		if err := WriteFile(fileName, []byte(content), testMode(), WithBackupRotation(2)); err != nil {
+			t.Fatalf("Error writing to file: %v", err)
+		}
+	}
+	assertFile(t, fileName, []byte("v4"), testMode())
+	assertFile(t, fileName+".1", []byte("v3"), testMode())
+	assertFile(t, fileName+".2", []byte("v2"), testMode())
+	assertFileCount(t, tmpDir, 3)
+}
+
+func TestCopyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.WriteFile(src, []byte("file content"), testMode()); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(src, dst, testMode()); err != nil {
+		t.Fatalf("Error copying file: %v", err)
+	}
+	assertFile(t, dst, []byte("file content"), testMode())
+	if err := copyFile(src, dst, testMode()); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected %v, got %v", os.ErrExist, err)
+	}
+}
+
+func TestWriterCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	assertFile(t, fileName, []byte{}, testMode())
+	assertFileCount(t, tmpDir, 1)
+
+	if err := w.Abort(); err != nil {
+		t.Errorf("Abort after Commit should be a no-op, got %v", err)
+	}
+	if err := w.Commit(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected %v, got %v", os.ErrClosed, err)
+	}
+	if _, err := w.Write([]byte("file content")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected %v, got %v", os.ErrClosed, err)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(fileName, []byte("original content"), testMode()); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	if _, err := w.Write([]byte("file content")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Error aborting: %v", err)
+	}
+	assertFile(t, fileName, []byte("original content"), testMode())
+	assertFileCount(t, tmpDir, 1)
+	if err := w.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected %v, got %v", os.ErrClosed, err)
+	}
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	fileContent := []byte("file content")
+	digest := sha256.Sum256(fileContent)
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "digest", opts: []Option{WithExpectedDigest(sha256.New(), digest[:]), WithExpectedSize(int64(len(fileContent)))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := filepath.Join(tmpDir, "src")
+			if err := os.WriteFile(src, fileContent, testMode()); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			fileName := filepath.Join(tmpDir, "test.txt")
+			w, err := NewWriter(fileName, append([]Option{WithMode(testMode())}, tc.opts...)...)
+			if err != nil {
+				t.Fatalf("Error creating writer: %v", err)
+			}
+			defer w.Abort()
+			n, err := io.Copy(w, f)
+			if err != nil {
+				t.Fatalf("Error copying: %v", err)
+			}
+			if n != int64(len(fileContent)) {
+				t.Errorf("Expected %d bytes, got %d", len(fileContent), n)
+			}
+			if err := w.Commit(); err != nil {
+				t.Fatalf("Error committing: %v", err)
+			}
+			assertFile(t, fileName, fileContent, testMode())
+		})
+	}
+}
+
+func TestWriterReadFromError(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	expectedErr := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(expectedErr))
+	if _, err := w.ReadFrom(r); !errors.Is(err, expectedErr) {
+		t.Fatalf("Expected %v, got %v", expectedErr, err)
+	}
+	if err := w.Commit(); !errors.Is(err, expectedErr) {
+		t.Errorf("Expected %v, got %v", expectedErr, err)
+	}
+	assertFileCount(t, tmpDir, 0)
+}
+
+func TestWriterSeekTruncate(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	w, err := NewWriter(fileName, WithMode(testMode()), WithExpectedSize(8))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	if _, err := w.Write([]byte("file content")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if err := w.Truncate(4); err != nil {
+		t.Fatalf("Error truncating: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("Error seeking: %v", err)
+	}
+	if _, err := w.Write([]byte("1234")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Error seeking: %v", err)
+	}
+	if _, err := w.Write([]byte("F")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	assertFile(t, fileName, []byte("File1234"), testMode())
+
+	digest := sha256.Sum256(nil)
+	w, err = NewWriter(fileName, WithExpectedDigest(sha256.New(), digest[:]))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	if _, err := w.Seek(0, io.SeekCurrent); err != nil {
+		t.Errorf("Querying the offset should be allowed, got %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); err == nil {
+		t.Errorf("Seeking should not be allowed when verifying the digest")
+	}
+	if err := w.Truncate(0); err == nil {
+		t.Errorf("Truncating should not be allowed when verifying the digest")
+	}
+}
+
+func TestWriterCopySparse(t *testing.T) {
+	const blockSize = sparseBlockSize
+	content := make([]byte, 4*blockSize+100)
+	copy(content[blockSize:], "data")
+	copy(content[3*blockSize+10:], "more data")
+	digest := sha256.Sum256(content)
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "digest", opts: []Option{WithExpectedDigest(sha256.New(), digest[:]), WithExpectedSize(int64(len(content)))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			fileName := filepath.Join(tmpDir, "test.txt")
+			w, err := NewWriter(fileName, append([]Option{WithMode(testMode())}, tc.opts...)...)
+			if err != nil {
+				t.Fatalf("Error creating writer: %v", err)
+			}
+			defer w.Abort()
+			n, err := w.CopySparse(bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatalf("Error copying: %v", err)
+			}
+			if n != int64(len(content)) {
+				t.Errorf("Expected %d bytes, got %d", len(content), n)
+			}
+			if err := w.Commit(); err != nil {
+				t.Fatalf("Error committing: %v", err)
+			}
+			assertFile(t, fileName, content, testMode())
+		})
+	}
+}
+
+func TestWriterCopySparseOverwrite(t *testing.T) {
+	// Zero blocks must be written if they overwrite previous content.
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	defer w.Abort()
+	if _, err := w.Write(bytes.Repeat([]byte("x"), sparseBlockSize+10)); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Error seeking: %v", err)
+	}
+	if _, err := w.CopySparse(bytes.NewReader(make([]byte, sparseBlockSize)), sparseBlockSize); err != nil {
+		t.Fatalf("Error copying: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	assertFile(t, fileName, append(make([]byte, sparseBlockSize), "xxxxxxxxxx"...), testMode())
+}
+
+func TestWriterCopySparseShort(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileName := filepath.Join(tmpDir, "test.txt")
+	w, err := NewWriter(fileName, WithMode(testMode()))
+	if err != nil {
+		t.Fatalf("Error creating writer: %v", err)
+	}
+	if _, err := w.CopySparse(strings.NewReader("file content"), 100); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if err := w.Commit(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	assertFileCount(t, tmpDir, 0)
+}
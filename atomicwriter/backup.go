@@ -0,0 +1,84 @@
+package atomicwriter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// prepareBackup preserves the current version of the file at fn before it
+// is replaced, as configured through [WithBackup] or [WithBackupRotation], by
+// linking (or copying) it to a temporary name, which is returned. It returns
+// an empty name if fn does not exist or is not a regular file, in which case
+// no backup is made.
+//
+// The backup is only moved into place by commitBackup once fn was replaced,
+// so that existing backups are left unchanged if replacing fn fails.
+func prepareBackup(fn string) (string, error) {
+	fi, err := os.Lstat(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", nil
+	}
+
+	dir, base := filepath.Split(fn)
+	tmp := filepath.Join(dir, tempName(tempFilePrefix, base))
+	if err := os.Link(fn, tmp); err != nil {
+		// Hard links may not be supported by the filesystem, or the
+		// maximum number of links may be reached; fall back to copying.
+		if err := copyFile(fn, tmp, fi.Mode().Perm()); err != nil {
+			return "", err
+		}
+	}
+	return tmp, nil
+}
+
+// commitBackup moves the previous version of fn, preserved under the name tmp
+// by prepareBackup, to its backup name, atomically replacing any existing
+// backup. If rotate is greater than 0, older backups are shifted first.
+func commitBackup(fn, tmp string, rotate int) error {
+	target := fn + ".bak"
+	if rotate > 0 {
+		for i := rotate - 1; i > 0; i-- {
+			err := os.Rename(fn+"."+strconv.Itoa(i), fn+"."+strconv.Itoa(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		target = fn + ".1"
+	}
+	return os.Rename(tmp, target)
+}
+
+// copyFile copies the content of src to a new file dst.
+func copyFile(src, dst string, perm os.FileMode) (retErr error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package atomicwriter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Temporary files and directories created by this package are named using
+// one of these prefixes, and the tempSuffix marker, so that [CleanupStale]
+// can recognize them.
+const (
+	tempFilePrefix = ".tmp-"      // Temporary files, see [New].
+	oldDirPrefix   = ".old-"      // Previous contents, see [WriteSet.CommitReplace].
+	writeSetPrefix = "write-set-" // Write sets, see [NewWriteSet].
+	tempSuffix     = ".atomicwriter"
+)
+
+// tempPattern returns a pattern for [os.CreateTemp] to create a temporary
+// file for base.
+func tempPattern(prefix, base string) string {
+	return prefix + base + "-*" + tempSuffix
+}
+
+// tempName returns a random temporary file name for base.
+func tempName(prefix, base string) string {
+	return prefix + base + "-" + randomSuffix() + tempSuffix
+}
+
+// randomSuffix returns a random string to use in temporary file names.
+func randomSuffix() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// isTempName reports whether name is the name of a temporary file or
+// directory created by this package.
+func isTempName(name string) bool {
+	if !strings.HasSuffix(name, tempSuffix) {
+		return false
+	}
+	return strings.HasPrefix(name, tempFilePrefix) ||
+		strings.HasPrefix(name, oldDirPrefix) ||
+		strings.HasPrefix(name, writeSetPrefix)
+}
+
+// isLegacyTempName reports whether name is the name of a temporary file or
+// write set directory created by earlier versions of this package, which
+// did not use tempSuffix. These are named ".tmp-<name><random>" and
+// "write-set-<random>", where <random> is the decimal number appended by
+// [os.CreateTemp] and [os.MkdirTemp].
+func isLegacyTempName(name string, isDir bool) bool {
+	prefix := tempFilePrefix
+	if isDir {
+		prefix = writeSetPrefix
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	rest := strings.TrimRight(name[len(prefix):], "0123456789")
+	if len(rest) == len(name)-len(prefix) {
+		// No random suffix.
+		return false
+	}
+	// The name of the destination comes before the random suffix of
+	// temporary files, but write sets only have a random suffix.
+	return isDir == (rest == "")
+}
+
+// lastModified returns the time the entry at path, described by fi, was last
+// modified. For directories, such as write sets, it is the time any entry
+// within was last modified, as writing files to a directory does not change
+// the modification time of the directory itself.
+func lastModified(path string, fi os.FileInfo) time.Time {
+	mtime := fi.ModTime()
+	if !fi.IsDir() {
+		return mtime
+	}
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip entries that can not be read, or were removed.
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(mtime) {
+			mtime = info.ModTime()
+		}
+		return nil
+	})
+	return mtime
+}
+
+// CleanupStale removes temporary files and directories that were left behind
+// in dir by this package, for example, when a process crashed before closing
+// a writer returned by [New], or before committing or canceling a [WriteSet].
+// It is intended to be called on startup, with dir being the directory files
+// are written to, or the directory passed to [NewWriteSet].
+//
+// Temporary files and write sets left behind by earlier versions of this
+// package, named ".tmp-<name><random>" and "write-set-<random>", are removed
+// as well.
+//
+// Only entries that were last modified more than olderThan ago are removed.
+// For directories, such as write sets, this is the last time any entry in
+// the directory was modified.
+// The olderThan duration should be chosen to be longer than any write may be
+// in progress, as CleanupStale cannot distinguish abandoned files from files
+// that are still being written by another process.
+//
+// CleanupStale continues when failing to remove an entry, and returns the
+// first error that occurred.
+func CleanupStale(dir string, olderThan time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	var retErr error
+	for _, entry := range entries {
+		if !isTempName(entry.Name()) && !isLegacyTempName(entry.Name(), entry.IsDir()) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			if !os.IsNotExist(err) && retErr == nil {
+				retErr = err
+			}
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if lastModified(path, fi).After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
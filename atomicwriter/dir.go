@@ -0,0 +1,112 @@
+package atomicwriter
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dir is a directory that files can be atomically written to. Unlike the
+// functions in this package that take a path, which is resolved again for
+// every operation, a Dir refers to the directory by a handle that is opened
+// once. Names are resolved relative to that handle and are not allowed to
+// escape from it, even if the tree is concurrently modified, which makes it
+// safe to write to untrusted trees, such as the root filesystem of a
+// container.
+//
+// On Linux, names are resolved using openat2(2) with RESOLVE_BENEATH, so
+// that symlinks within the directory are followed, but cannot point outside
+// of it. On kernels without openat2(2), each parent directory of a name is
+// opened using O_NOFOLLOW, and symlinks in the parent directories of a name
+// are rejected. The destination itself is never allowed to be a symlink.
+//
+// Dir is only supported on Linux.
+type Dir struct {
+	f *os.File
+}
+
+// OpenDir opens the directory at path for writing files atomically.
+// The directory is resolved once; renaming or replacing path afterwards
+// does not affect the returned Dir.
+func OpenDir(path string) (*Dir, error) {
+	f, err := openDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{f: f}, nil
+}
+
+// Name returns the path the directory was opened with.
+func (d *Dir) Name() string {
+	return d.f.Name()
+}
+
+// Close closes the directory. Writers created by [Dir.New] that were not
+// closed yet remain usable.
+func (d *Dir) Close() error {
+	return d.f.Close()
+}
+
+// New is like the package-level [New], but name is relative to d, and must
+// not refer to a location outside of d, or a [*PathEscapeError] is returned.
+// The parent directory of name must exist.
+//
+// The temporary file is created in the parent directory of name, and renamed
+// (or linked) into place relative to that directory when closing the writer.
+// With [WithSyncDir], that directory is synced through the same handle.
+//
+// The [WithFollowSymlinks], [WithPreserve], [WithBackup] and
+// [WithBackupRotation] options operate on paths, and are not supported.
+func (d *Dir) New(name string, perm os.FileMode, opts ...Option) (io.WriteCloser, error) {
+	w, err := d.NewWriter(name, append([]Option{WithMode(perm)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriter is like [Dir.New], but takes all file attributes as options, as
+// for [NewWriter], and returns a [*Writer].
+func (d *Dir) NewWriter(name string, opts ...Option) (*Writer, error) {
+	o := applyOptions(opts)
+	if o.followSymlinks || o.preserve || o.backup {
+		return nil, errors.New("option is not supported for writes relative to a directory")
+	}
+	clean, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	parent, base := filepath.Split(clean)
+	dir, err := d.openParent(filepath.Clean(parent))
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		fn:   filepath.Join(d.f.Name(), clean),
+		dir:  dir,
+		name: base,
+		opts: o,
+	}
+	if err := validateAt(dir, base, w.fn); err != nil {
+		_ = dir.Close()
+		return nil, err
+	}
+	w.f, w.tmpName, err = createTempAt(dir, base)
+	if err != nil {
+		_ = dir.Close()
+		return nil, err
+	}
+	w.anonymous = w.tmpName == ""
+	return start(w)
+}
+
+// WriteFile is like the package-level [WriteFile], but name is relative to
+// d, as for [Dir.New].
+func (d *Dir) WriteFile(name string, data []byte, perm os.FileMode, opts ...Option) error {
+	w, err := d.NewWriter(name, append([]Option{WithMode(perm)}, opts...)...)
+	if err != nil {
+		return err
+	}
+	return writeAndCommit(w, data)
+}
@@ -0,0 +1,140 @@
+package atomicwriter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func openDir(path string) (*os.File, error) {
+	fd, err := unix.Open(path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openParent opens the directory parent, relative to d. Opening it fails
+// with a [*PathEscapeError] if resolving parent escapes from d.
+func (d *Dir) openParent(parent string) (*os.File, error) {
+	path := filepath.Join(d.f.Name(), parent)
+	how := &unix.OpenHow{
+		Flags:   unix.O_DIRECTORY | unix.O_RDONLY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	for i := 0; ; i++ {
+		fd, err := unix.Openat2(int(d.f.Fd()), parent, how)
+		switch {
+		case err == nil:
+			return os.NewFile(uintptr(fd), path), nil
+		case errors.Is(err, unix.EAGAIN) && i < 10:
+			// The tree was concurrently modified while resolving.
+			continue
+		case errors.Is(err, unix.EXDEV):
+			return nil, &PathEscapeError{Name: parent}
+		case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EPERM):
+			// openat2 is not supported by the kernel, or blocked by a
+			// seccomp profile.
+			return d.walkParent(parent)
+		default:
+			return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+		}
+	}
+}
+
+// walkParent is the fallback for openParent if openat2 is not available.
+// It opens each component of parent using O_NOFOLLOW, rejecting symlinks.
+func (d *Dir) walkParent(parent string) (*os.File, error) {
+	path := filepath.Join(d.f.Name(), parent)
+	fd, err := unix.Openat(int(d.f.Fd()), ".", unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: d.f.Name(), Err: err}
+	}
+	if parent != "." {
+		for _, c := range strings.Split(parent, "/") {
+			next, err := unix.Openat(fd, c, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+			_ = unix.Close(fd)
+			if err != nil {
+				return nil, &os.PathError{Op: "openat", Path: path, Err: err}
+			}
+			fd = next
+		}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// validateAt is like validateDestination, for the destination name in the
+// directory dir. fn is the full path of the destination used in errors.
+func validateAt(dir *os.File, name, fn string) error {
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat output path: %w", &os.PathError{Op: "openat", Path: fn, Err: err})
+	}
+	f := os.NewFile(uintptr(fd), fn)
+	fi, err := f.Stat()
+	_ = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to stat output path: %w", err)
+	}
+	return validateMode(fi.Mode())
+}
+
+// createTempAt creates a temporary file in dir to replace name. It creates
+// an anonymous temporary file if possible, in which case the returned tmpName
+// is empty, as for createTemp.
+func createTempAt(dir *os.File, name string) (f *os.File, tmpName string, err error) {
+	dirfd := int(dir.Fd())
+	if procSelfFD() {
+		fd, err := unix.Openat(dirfd, ".", unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0o600)
+		if err == nil {
+			return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), tempPattern(tempFilePrefix, name))), "", nil
+		}
+	}
+	for i := 0; i < 100; i++ {
+		tmpName = tempName(tempFilePrefix, name)
+		fd, err := unix.Openat(dirfd, tmpName, unix.O_CREAT|unix.O_EXCL|unix.O_RDWR|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0o600)
+		if errors.Is(err, unix.EEXIST) {
+			continue
+		}
+		if err != nil {
+			return nil, "", &os.PathError{Op: "openat", Path: filepath.Join(dir.Name(), tmpName), Err: err}
+		}
+		return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), tmpName)), tmpName, nil
+	}
+	return nil, "", &os.PathError{Op: "openat", Path: filepath.Join(dir.Name(), tempPattern(tempFilePrefix, name)), Err: unix.EEXIST}
+}
+
+func removeAt(dir *os.File, name string) error {
+	if err := unix.Unlinkat(int(dir.Fd()), name, 0); err != nil {
+		return &os.PathError{Op: "unlinkat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return nil
+}
+
+func linkTempAt(f *os.File, dir *os.File, name string) error {
+	return linkTempFd(f, int(dir.Fd()), name)
+}
+
+func renameAt(dir *os.File, oldname, newname string) error {
+	dirfd := int(dir.Fd())
+	if err := unix.Renameat(dirfd, oldname, dirfd, newname); err != nil {
+		return &os.LinkError{Op: "renameat", Old: filepath.Join(dir.Name(), oldname), New: filepath.Join(dir.Name(), newname), Err: err}
+	}
+	return nil
+}
+
+func chtimesAt(dir *os.File, name string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	if err := unix.UtimesNanoAt(int(dir.Fd()), name, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimensat", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return nil
+}
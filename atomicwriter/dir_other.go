@@ -0,0 +1,43 @@
+//go:build !linux
+
+package atomicwriter
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errDirNotSupported = errors.New("writes relative to a directory are not supported on this platform")
+
+func openDir(string) (*os.File, error) {
+	return nil, errDirNotSupported
+}
+
+func (d *Dir) openParent(string) (*os.File, error) {
+	return nil, errDirNotSupported
+}
+
+func validateAt(*os.File, string, string) error {
+	return errDirNotSupported
+}
+
+func createTempAt(*os.File, string) (*os.File, string, error) {
+	return nil, "", errDirNotSupported
+}
+
+func removeAt(*os.File, string) error {
+	return errDirNotSupported
+}
+
+func linkTempAt(*os.File, *os.File, string) error {
+	return errDirNotSupported
+}
+
+func renameAt(*os.File, string, string) error {
+	return errDirNotSupported
+}
+
+func chtimesAt(*os.File, string, time.Time, time.Time) error {
+	return errDirNotSupported
+}
@@ -2,6 +2,8 @@ module github.com/moby/sys/atomicwriter
 
 go 1.18
 
-require github.com/moby/sys/sequential v0.6.0
-
-require golang.org/x/sys v0.1.0 // indirect
+require (
+	github.com/moby/sys/sequential v0.6.0
+	github.com/moby/sys/symlink v0.3.0
+	golang.org/x/sys v0.1.0
+)
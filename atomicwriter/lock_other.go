@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+
+package atomicwriter
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLock is not supported on platforms without flock(2), such as AIX.
+func tryLock(*os.File) error {
+	return errors.New("file locking is not supported on this platform")
+}
@@ -0,0 +1,274 @@
+package atomicwriter
+
+import (
+	"encoding/hex"
+	"hash"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Option is a functional option that can be passed to [New], [NewWithOptions],
+// [WriteFile], and [WriteSet.Commit] to change their default behavior.
+type Option func(*options)
+
+type options struct {
+	syncDir bool
+
+	mode         os.FileMode
+	umask        bool
+	chown        bool
+	uid, gid     int
+	xattrs       map[string][]byte
+	atime, mtime time.Time
+	chtimes      bool
+
+	followSymlinks bool
+	symlinkRoot    string
+
+	preserve bool
+
+	backup       bool
+	backupRotate int
+
+	preallocate int64
+
+	digestHash     hash.Hash
+	expectedDigest []byte
+	expectedSize   int64 // -1 if the size is not verified
+}
+
+func applyOptions(opts []Option) options {
+	o := options{mode: 0o600, expectedSize: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithSyncDir makes the write durable by syncing the destination directory
+// after the file (or write set) has been renamed into place. Without this
+// option, the rename may be lost after a power failure or system crash, even
+// though the data of the file itself was synced. For a [WriteSet], all
+// directories in the set are also synced before it is renamed, so that the
+// entries created in its subdirectories are durable as well.
+//
+// If syncing the directory fails, a [*SyncDirError] is returned. In that case,
+// the new content is already visible at the destination, but may not survive
+// a crash.
+//
+// Syncing directories is not supported on Windows, where this option is a
+// no-op.
+func WithSyncDir() Option {
+	return func(o *options) {
+		o.syncDir = true
+	}
+}
+
+// WithMode sets the permission bits of the file. It defaults to 0o600 for
+// [NewWithOptions], and is set to the perm argument by [New] and [WriteFile].
+//
+// The mode is applied as-is, unless [WithUmask] is also used.
+func WithMode(perm os.FileMode) Option {
+	return func(o *options) {
+		o.mode = perm
+	}
+}
+
+// WithUmask applies the umask of the current process to the mode of the
+// file, similar to [os.WriteFile]. It is a no-op on platforms without a
+// umask, such as Windows.
+//
+// The umask is read from /proc/self/status on Linux 4.7 and up. Elsewhere, it
+// can not be read without briefly changing it for the whole process, which
+// would affect files created concurrently by other goroutines; the umask
+// of the process at initialization of this package is used instead, and
+// later changes to the umask are not taken into account.
+func WithUmask() Option {
+	return func(o *options) {
+		o.umask = true
+	}
+}
+
+// WithChown sets the owner and group of the file. A uid or gid of -1 means
+// to not change that value. Changing ownership is not supported on Windows.
+func WithChown(uid, gid int) Option {
+	return func(o *options) {
+		o.chown = true
+		o.uid, o.gid = uid, gid
+	}
+}
+
+// WithXattrs sets the given extended attributes on the file, for example
+// "security.capability" or "user.*" attributes. Setting extended attributes
+// is only supported on Linux.
+func WithXattrs(xattrs map[string][]byte) Option {
+	return func(o *options) {
+		if o.xattrs == nil {
+			o.xattrs = make(map[string][]byte, len(xattrs))
+		}
+		for k, v := range xattrs {
+			o.xattrs[k] = v
+		}
+	}
+}
+
+// WithTimes sets the access and modification times of the file, similar
+// to [os.Chtimes]. A zero [time.Time] value leaves the corresponding time
+// set to the time the file was last written.
+func WithTimes(atime, mtime time.Time) Option {
+	return func(o *options) {
+		o.chtimes = true
+		o.atime, o.mtime = atime, mtime
+	}
+}
+
+// WithFollowSymlinks allows the destination to be a symbolic link. Instead
+// of rejecting it, the symlink is resolved and its target is atomically
+// replaced, leaving the symlink itself in place. This makes [WriteFile] a
+// drop-in replacement for [os.WriteFile] for files such as /etc/resolv.conf,
+// which are often symlinks.
+//
+// Symlinks are resolved using [symlink.FollowSymlinkInScope] within the given
+// root, so that the resolved target is guaranteed to be contained within root
+// at the time of the call. If root is empty, symlinks are resolved without
+// restriction. The target of the symlink does not have to exist.
+func WithFollowSymlinks(root string) Option {
+	return func(o *options) {
+		o.followSymlinks = true
+		o.symlinkRoot = root
+	}
+}
+
+// WithPreserve preserves the metadata of the file that is being replaced.
+// When the destination exists, its owner, group and permission bits are
+// copied to the new file instead of those set through [WithMode], [WithUmask]
+// and [WithChown]. On Linux, its extended attributes, including POSIX ACLs
+// and SELinux labels, are copied as well, except for "security.capability",
+// as file capabilities granted to the old content should not be granted to
+// the new content. Extended attributes set through [WithXattrs] take
+// precedence over preserved ones.
+//
+// If the destination does not exist, WithPreserve is a no-op. Failing to copy
+// any of the attributes is an error, so that replacing a file does not
+// silently change its security attributes.
+func WithPreserve() Option {
+	return func(o *options) {
+		o.preserve = true
+	}
+}
+
+// WithBackup keeps the previous version of the destination as a backup
+// file, named after the destination with a ".bak" suffix, so that a bad
+// write can be rolled back. The previous version is preserved right before
+// the destination is replaced, and moved into place once it was replaced,
+// atomically replacing any existing backup. It is a hard link to the
+// previous version if possible, or a copy if hard links are not supported,
+// for example on some network filesystems.
+//
+// If the destination does not exist or is not a regular file, no backup is
+// made. Failing to preserve the previous version is an error, and leaves the
+// destination unchanged. If replacing the destination fails, existing
+// backups are left unchanged.
+func WithBackup() Option {
+	return func(o *options) {
+		o.backup = true
+		o.backupRotate = 0
+	}
+}
+
+// WithBackupRotation is like [WithBackup], but keeps up to n previous
+// versions of the destination, named after the destination with a ".1",
+// ".2", ... ".n" suffix, where ".1" is the most recent one. Once the
+// destination was replaced, older backups are shifted, dropping the oldest
+// one, and the previous version is moved to ".1". A value of n smaller than 1 is equivalent to [WithBackup].
+func WithBackupRotation(n int) Option {
+	return func(o *options) {
+		o.backup = true
+		o.backupRotate = n
+	}
+}
+
+// WithPreallocate allocates disk space for size bytes when the writer is
+// created, so that writing a large file does not fragment it, and so that
+// creating the writer fails early if there is not enough space left. The
+// size of the file is not changed; writing less than size bytes does not
+// leave trailing zeros.
+//
+// Preallocating is only supported on Linux, using fallocate(2), and is
+// silently skipped if the filesystem does not support it. It is a no-op on
+// other platforms. Preallocated space is not released for ranges that are
+// skipped by [Writer.CopySparse].
+func WithPreallocate(size int64) Option {
+	return func(o *options) {
+		o.preallocate = size
+	}
+}
+
+// WithExpectedDigest verifies the digest of the content written. The content
+// is hashed using h while it is written, and closing the writer returns a
+// [*MismatchError] without replacing the destination if the resulting digest
+// is not equal to expected. The hash is reset when the writer is created, and
+// must not be shared between writers. For example, use [sha256.New] to verify
+// a sha256 digest.
+//
+// [sha256.New]: https://pkg.go.dev/crypto/sha256#New
+func WithExpectedDigest(h hash.Hash, expected []byte) Option {
+	return func(o *options) {
+		o.digestHash = h
+		o.expectedDigest = expected
+	}
+}
+
+// WithExpectedSize verifies the size of the content written. Closing the
+// writer returns a [*MismatchError] without replacing the destination if the
+// number of bytes written is not equal to size.
+func WithExpectedSize(size int64) Option {
+	return func(o *options) {
+		o.expectedSize = size
+	}
+}
+
+// MismatchError is returned when closing a writer created with
+// [WithExpectedDigest] or [WithExpectedSize] if the content written does not
+// have the expected digest or size. The destination is not replaced in that
+// case. The size is verified first; if it does not match, the digest is not
+// compared, DigestMismatch is false, and ExpectedDigest and Digest are nil.
+type MismatchError struct {
+	// Filename is the destination that was not replaced.
+	Filename string
+	// DigestMismatch is true if the digest did not match, and false if the
+	// size did not match.
+	DigestMismatch bool
+	// ExpectedSize and Size are the expected and actual size of the content.
+	// ExpectedSize is -1 if the size was not verified.
+	ExpectedSize, Size int64
+	// ExpectedDigest and Digest are the expected and actual digest of the
+	// content if the digest did not match.
+	ExpectedDigest, Digest []byte
+}
+
+func (e *MismatchError) Error() string {
+	if e.DigestMismatch {
+		return "digest mismatch for " + e.Filename + ": expected " + hex.EncodeToString(e.ExpectedDigest) + ", got " + hex.EncodeToString(e.Digest)
+	}
+	return "size mismatch for " + e.Filename + ": expected " + strconv.FormatInt(e.ExpectedSize, 10) + " bytes, got " + strconv.FormatInt(e.Size, 10)
+}
+
+// SyncDirError is returned when the destination was successfully replaced,
+// but syncing its parent directory failed.
+type SyncDirError struct {
+	// Dir is the directory that failed to sync.
+	Dir string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *SyncDirError) Error() string {
+	return "failed to sync directory " + e.Dir + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *SyncDirError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,17 @@
+//go:build !windows && !plan9
+
+package atomicwriter
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and group of the file described by fi.
+func fileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package atomicwriter
+
+// exchange is not supported on this platform, and always returns
+// errExchangeNotSupported.
+func exchange(string, string) error {
+	return errExchangeNotSupported
+}
@@ -0,0 +1,123 @@
+package atomicwriter
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the size of the blocks copied by [Writer.CopySparse].
+const sparseBlockSize = 64 * 1024
+
+// CopySparse copies size bytes from src, starting at offset 0 of src, to the
+// temporary file at the current offset, preserving holes. On Linux, holes in
+// src are detected using SEEK_DATA and SEEK_HOLE if src is an [*os.File];
+// the offset of src is left unchanged. In addition, blocks that only contain
+// zeros are not written, so that they become holes as well.
+//
+// Holes are only created when appending to the temporary file, as skipped
+// ranges must read as zeros; otherwise, they are written as zeros. It returns
+// the number of bytes copied, including holes. If src has less than size
+// bytes, [io.ErrUnexpectedEOF] is returned. If an error occurs, the write can
+// no longer be committed.
+func (w *Writer) CopySparse(src io.ReaderAt, size int64) (int64, error) {
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	w.written = true
+	if f, ok := src.(*os.File); ok {
+		if off, err := f.Seek(0, io.SeekCurrent); err == nil {
+			defer f.Seek(off, io.SeekStart) //nolint:errcheck // Best effort.
+		}
+	}
+	n, err := w.copySparse(src, size)
+	if err != nil {
+		w.writeErr = err
+	}
+	return n, err
+}
+
+func (w *Writer) copySparse(src io.ReaderAt, size int64) (int64, error) {
+	base, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := w.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	sparse := base >= fi.Size()
+
+	buf := make([]byte, sparseBlockSize)
+	var zeros []byte
+	for off := int64(0); off < size; {
+		start, end, err := nextData(src, off, size)
+		if err != nil {
+			return off, err
+		}
+		// The range from off to start is a hole in src.
+		if start > off && (!sparse || w.opts.digestHash != nil) {
+			if zeros == nil {
+				zeros = make([]byte, sparseBlockSize)
+			}
+			for ; off < start; off += int64(len(zeros)) {
+				n := int64(len(zeros))
+				if start-off < n {
+					n = start - off
+				}
+				if err := w.writeBlock(zeros[:n], base+off, sparse); err != nil {
+					return off, err
+				}
+			}
+		}
+		for off = start; off < end; {
+			n := int64(len(buf))
+			if end-off < n {
+				n = end - off
+			}
+			nr, err := src.ReadAt(buf[:n], off)
+			if int64(nr) < n {
+				if err == nil || errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				return off, err
+			}
+			if err := w.writeBlock(buf[:n], base+off, sparse && isZero(buf[:n])); err != nil {
+				return off, err
+			}
+			off += n
+		}
+	}
+	if sparse {
+		if err := w.f.Truncate(base + size); err != nil {
+			return size, err
+		}
+	}
+	if _, err := w.f.Seek(base+size, io.SeekStart); err != nil {
+		return size, err
+	}
+	return size, nil
+}
+
+// writeBlock writes b to the temporary file at off, or skips it if skip is
+// set, in which case b must only contain zeros. The digest is updated in
+// both cases.
+func (w *Writer) writeBlock(b []byte, off int64, skip bool) error {
+	if w.opts.digestHash != nil {
+		w.opts.digestHash.Write(b)
+	}
+	if skip {
+		return nil
+	}
+	_, err := w.f.WriteAt(b, off)
+	return err
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
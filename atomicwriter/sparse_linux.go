@@ -0,0 +1,69 @@
+package atomicwriter
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate allocates disk space for size bytes of f without changing its
+// size. It returns nil if the filesystem does not support fallocate(2).
+func preallocate(f *os.File, size int64) error {
+	for {
+		err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, unix.EINTR):
+			continue
+		case errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.ENOSYS):
+			return nil
+		default:
+			return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+		}
+	}
+}
+
+// nextData returns the next range of data in src, starting at or after off
+// and ending at or before size, using SEEK_DATA and SEEK_HOLE if src is an
+// [*os.File]. If there is no more data, start and end are equal to size.
+// It changes the offset of src.
+func nextData(src io.ReaderAt, off, size int64) (start, end int64, err error) {
+	f, ok := src.(*os.File)
+	if !ok {
+		return off, size, nil
+	}
+	fd := int(f.Fd())
+	start, err = unix.Seek(fd, off, unix.SEEK_DATA)
+	switch {
+	case errors.Is(err, unix.ENXIO):
+		// No data after off; off is either in a trailing hole, or beyond
+		// the end of src.
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, 0, err
+		}
+		if fi.Size() < size {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return size, size, nil
+	case errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+		// Not supported by the filesystem.
+		return off, size, nil
+	case err != nil:
+		return 0, 0, &os.PathError{Op: "seek", Path: f.Name(), Err: err}
+	}
+	if start >= size {
+		return size, size, nil
+	}
+	end, err = unix.Seek(fd, start, unix.SEEK_HOLE)
+	if err != nil {
+		return 0, 0, &os.PathError{Op: "seek", Path: f.Name(), Err: err}
+	}
+	if end > size {
+		end = size
+	}
+	return start, end, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package atomicwriter
+
+import (
+	"io"
+	"os"
+)
+
+// preallocate is a no-op on this platform.
+func preallocate(*os.File, int64) error {
+	return nil
+}
+
+// nextData returns the remainder of src as data, as detecting holes is not
+// supported on this platform.
+func nextData(_ io.ReaderAt, off, size int64) (start, end int64, err error) {
+	return off, size, nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package atomicwriter
+
+import "os"
+
+// syncDir flushes the directory entries of dir to stable storage.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+package atomicwriter
+
+// syncDir is a no-op on Windows, which does not support flushing directory
+// handles; NTFS journals the metadata of a rename.
+func syncDir(string) error {
+	return nil
+}
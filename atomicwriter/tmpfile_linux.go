@@ -0,0 +1,86 @@
+package atomicwriter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/moby/sys/sequential"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	procSelfFDOnce sync.Once
+	procSelfFDOK   bool
+)
+
+// procSelfFD reports whether /proc/self/fd is available, which is needed to
+// link an anonymous temporary file into place without CAP_DAC_READ_SEARCH.
+func procSelfFD() bool {
+	procSelfFDOnce.Do(func() {
+		_, err := os.Stat("/proc/self/fd")
+		procSelfFDOK = err == nil
+	})
+	return procSelfFDOK
+}
+
+// createTemp creates an anonymous temporary file in dir using O_TMPFILE.
+// It falls back to a named temporary file using the given pattern (as for
+// [os.CreateTemp]) if O_TMPFILE is not supported by the kernel or filesystem.
+func createTemp(dir, pattern string) (f *os.File, anonymous bool, err error) {
+	if procSelfFD() {
+		fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0o600)
+		if err == nil {
+			return os.NewFile(uintptr(fd), filepath.Join(dir, pattern)), true, nil
+		}
+	}
+	f, err = sequential.CreateTemp(dir, pattern)
+	return f, false, err
+}
+
+// tempPath returns a path that refers to the temporary file f.
+func tempPath(f *os.File, anonymous bool) string {
+	if anonymous {
+		return "/proc/self/fd/" + strconv.Itoa(int(f.Fd()))
+	}
+	return f.Name()
+}
+
+// linkTemp links the anonymous temporary file f to dst. If dst does not exist,
+// f is linked directly. Otherwise, it is linked under a temporary name first,
+// and then renamed over dst.
+func linkTemp(f *os.File, dst string) error {
+	return linkTempFd(f, unix.AT_FDCWD, dst)
+}
+
+// linkTempFd is like linkTemp, but dst is relative to the directory dirfd.
+func linkTempFd(f *os.File, dirfd int, dst string) error {
+	src := tempPath(f, true)
+	err := unix.Linkat(unix.AT_FDCWD, src, dirfd, dst, unix.AT_SYMLINK_FOLLOW)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return &os.LinkError{Op: "linkat", Old: src, New: dst, Err: err}
+	}
+
+	dir, base := filepath.Split(dst)
+	for i := 0; i < 100; i++ {
+		tmp := filepath.Join(dir, tempName(tempFilePrefix, base))
+		err := unix.Linkat(unix.AT_FDCWD, src, dirfd, tmp, unix.AT_SYMLINK_FOLLOW)
+		if errors.Is(err, unix.EEXIST) {
+			continue
+		}
+		if err != nil {
+			return &os.LinkError{Op: "linkat", Old: src, New: tmp, Err: err}
+		}
+		if err := unix.Renameat(dirfd, tmp, dirfd, dst); err != nil {
+			_ = unix.Unlinkat(dirfd, tmp, 0)
+			return &os.LinkError{Op: "rename", Old: tmp, New: dst, Err: err}
+		}
+		return nil
+	}
+	return &os.LinkError{Op: "linkat", Old: src, New: filepath.Join(dir, tempPattern(tempFilePrefix, base)), Err: unix.EEXIST}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package atomicwriter
+
+import (
+	"errors"
+	"os"
+
+	"github.com/moby/sys/sequential"
+)
+
+// createTemp creates a named temporary file in dir using the given pattern,
+// as for [os.CreateTemp].
+func createTemp(dir, pattern string) (f *os.File, anonymous bool, err error) {
+	f, err = sequential.CreateTemp(dir, pattern)
+	return f, false, err
+}
+
+// tempPath returns a path that refers to the temporary file f.
+func tempPath(f *os.File, _ bool) string {
+	return f.Name()
+}
+
+// linkTemp is only used for anonymous temporary files, which are not
+// supported on this platform.
+func linkTemp(*os.File, string) error {
+	return errors.New("anonymous temporary files are not supported on this platform")
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package atomicwriter
+
+import "os"
+
+// umask always returns 0 on platforms without a file mode creation mask,
+// such as Windows.
+func umask() os.FileMode {
+	return 0
+}
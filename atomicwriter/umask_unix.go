@@ -0,0 +1,43 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package atomicwriter
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// startUmask is the file mode creation mask of the process when the package
+// was initialized. Obtaining it requires temporarily changing the umask,
+// which is only done once, during initialization, so that files created
+// concurrently by other goroutines are not affected.
+var startUmask = func() os.FileMode {
+	m := syscall.Umask(0)
+	syscall.Umask(m)
+	return os.FileMode(m)
+}()
+
+// umask returns the file mode creation mask of the current process.
+//
+// On Linux 4.7 and up, it is read from /proc/self/status. Otherwise, the
+// umask can not be obtained without changing it, and the umask of the process
+// at initialization is returned.
+func umask() os.FileMode {
+	if f, err := os.Open("/proc/self/status"); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if line := s.Text(); strings.HasPrefix(line, "Umask:") {
+				val := strings.TrimSpace(strings.TrimPrefix(line, "Umask:"))
+				if m, err := strconv.ParseUint(val, 8, 32); err == nil {
+					return os.FileMode(m)
+				}
+				break
+			}
+		}
+	}
+	return startUmask
+}
@@ -0,0 +1,93 @@
+package atomicwriter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// errLocked is returned by tryLock if the file is locked by someone else.
+var errLocked = errors.New("file is locked")
+
+// Update atomically updates the file named by filename, using fn to compute
+// the new content from the current content. It is equivalent to calling
+// [UpdateContext] with [context.Background].
+func Update(filename string, perm os.FileMode, fn func(old []byte) ([]byte, error), opts ...Option) error {
+	return UpdateContext(context.Background(), filename, perm, fn, opts...)
+}
+
+// UpdateContext atomically updates the file named by filename, using fn to
+// compute the new content from the current content. It can be used to
+// perform a read-modify-write cycle on a file that is updated concurrently
+// by multiple processes, without losing updates.
+//
+// UpdateContext takes an exclusive advisory lock on a lock file named
+// filename + ".lock", which is created with the given permission bits if
+// it does not exist, and is not removed afterwards. While holding the lock,
+// it reads the current content of filename, calls fn with it, and writes
+// the result using [WriteFile] with the given permission bits and options.
+// If filename does not exist, fn is called with a nil slice. If fn returns
+// an error, the file is not modified and the error is returned.
+//
+// The lock is only respected by other processes that use UpdateContext (or
+// otherwise lock the lock file). On Unix, the lock is acquired using flock(2);
+// on Windows, using LockFileEx. Locking, and therefore UpdateContext, is not
+// supported on other platforms, such as AIX, Plan 9 and WebAssembly, where
+// an error is returned.
+//
+// If the lock is held by someone else, UpdateContext waits for it to be
+// released until ctx is done, in which case the context's error is returned.
+func UpdateContext(ctx context.Context, filename string, perm os.FileMode, fn func(old []byte) ([]byte, error), opts ...Option) error {
+	if filename == "" {
+		return errors.New("file name is empty")
+	}
+	lock, err := lockFile(ctx, filename+".lock", perm)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	old, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := fn(old)
+	if err != nil {
+		return err
+	}
+	return WriteFile(filename, data, perm, opts...)
+}
+
+// lockFile acquires an exclusive lock on the file at path, creating it if
+// it does not exist, and waits for the lock to be released if it is held by
+// someone else until ctx is done. The lock is released by closing the file.
+func lockFile(ctx context.Context, path string, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, err
+	}
+	const maxDelay = 100 * time.Millisecond
+	delay := time.Millisecond
+	for {
+		err := tryLock(f)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errLocked) {
+			_ = f.Close()
+			return nil, &os.PathError{Op: "lock", Path: path, Err: err}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			_ = f.Close()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package atomicwriter
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrCapability is excluded when preserving extended attributes, as file
+// capabilities of the old content must not be granted to the new content.
+const xattrCapability = "security.capability"
+
+func setXattrs(f *os.File, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := unix.Fsetxattr(int(f.Fd()), name, value, 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: f.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// getXattrs returns the extended attributes of path, without following
+// symlinks. The "security.capability" attribute is omitted.
+func getXattrs(path string) (map[string][]byte, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		if name == xattrCapability {
+			continue
+		}
+		value, err := getXattr(path, name)
+		if err != nil {
+			if errors.Is(err, unix.ENODATA) {
+				// Removed after listing.
+				continue
+			}
+			return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil {
+			if errors.Is(err, unix.ENOTSUP) {
+				return nil, nil
+			}
+			return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		size, err = unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// Attributes were added after getting the size; retry.
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:size], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
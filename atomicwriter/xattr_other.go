@@ -0,0 +1,21 @@
+//go:build !linux
+
+package atomicwriter
+
+import (
+	"errors"
+	"os"
+)
+
+func setXattrs(f *os.File, xattrs map[string][]byte) error {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	return &os.PathError{Op: "setxattr", Path: f.Name(), Err: errors.New("extended attributes are not supported on this platform")}
+}
+
+// getXattrs returns no extended attributes, as they are not supported on
+// this platform.
+func getXattrs(string) (map[string][]byte, error) {
+	return map[string][]byte{}, nil
+}
@@ -8,6 +8,11 @@
 // Package capability provides utilities for manipulating POSIX capabilities.
 package capability
 
+import (
+	"errors"
+	"os"
+)
+
 type Capabilities interface {
 	// Get check whether a capability present in the given
 	// capabilities set. The 'which' value should be one of EFFECTIVE,
@@ -61,6 +66,85 @@ type Capabilities interface {
 	Apply(kind CapType) error
 }
 
+// FileCapabilities is implemented by the [Capabilities] returned by
+// [NewFile] and [NewFile2] on Linux. In addition to the file capability
+// sets, it gives access to the rootid of namespaced file capabilities
+// (VFS_CAP_REVISION_3), which are only effective in a user namespace
+// in which the rootid is mapped to root.
+//
+// Use [FileCapsToHost] and [FileCapsToContainer] to convert between
+// namespaced and non-namespaced (VFS_CAP_REVISION_2) file capabilities.
+type FileCapabilities interface {
+	Capabilities
+
+	// RootID returns the rootid of namespaced file capabilities, and
+	// true, or false if the file capabilities are not namespaced.
+	RootID() (uid int, ok bool)
+
+	// SetRootID makes the file capabilities namespaced, with the given
+	// rootid. The change takes effect on [Capabilities.Apply].
+	SetRootID(uid int)
+
+	// ClearRootID makes the file capabilities not namespaced. The change
+	// takes effect on [Capabilities.Apply].
+	ClearRootID()
+
+	// MarshalBinary returns the file capabilities, including changes not
+	// applied yet, as the raw value of the security.capability extended
+	// attribute. It fails if the file capabilities were not loaded.
+	MarshalBinary() ([]byte, error)
+}
+
+// ProcessCapabilities is implemented by the [Capabilities] returned by
+// [NewPid] and [NewPid2] on Linux. In addition to the capability sets, it
+// gives access to the securebits and the no_new_privs flag of the process,
+// as loaded by [Capabilities.Load].
+type ProcessCapabilities interface {
+	Capabilities
+
+	// Securebits returns the securebits of the process, and true, or false
+	// if they are not available. The kernel only exposes the securebits of
+	// the calling thread, so they are only available for pid 0.
+	Securebits() (Securebits, bool)
+
+	// NoNewPrivs returns whether the no_new_privs flag of the process is
+	// set.
+	NoNewPrivs() bool
+
+	// ApplyAll is like [Capabilities.Apply], but applies the changes to
+	// all threads of the current process, rather than only to the calling
+	// thread. Capabilities are a per-thread attribute, so Apply leaves the
+	// other threads of a multi-threaded program, such as any Go program,
+	// with their previous capabilities.
+	//
+	// ApplyAll uses [syscall.AllThreadsSyscall], and returns
+	// [ErrAllThreadsNotSupported] if that is not supported, which is the
+	// case when cgo is used. In that case, changes can be limited to a
+	// single goroutine by calling [runtime.LockOSThread] before Apply, and
+	// not unlocking the thread, so that it is not reused by other
+	// goroutines.
+	//
+	// The changes must succeed on all threads or on none: the Go runtime
+	// aborts the program otherwise. ApplyAll therefore reads the
+	// capabilities and seccomp mode of all threads first, and returns
+	// [ErrThreadsDiffer] without changing anything if they are not the
+	// same, for example because Apply was used on a locked thread. Threads
+	// must not change their capabilities concurrently with ApplyAll.
+	ApplyAll(kind CapType) error
+}
+
+var (
+	// ErrAllThreadsNotSupported is returned by
+	// [ProcessCapabilities.ApplyAll] if changing the capabilities of all
+	// threads is not supported.
+	ErrAllThreadsNotSupported = errors.New("capability: applying to all threads is not supported when cgo is used")
+
+	// ErrThreadsDiffer is returned by [ProcessCapabilities.ApplyAll] if
+	// the threads of the current process have different capabilities or
+	// seccomp modes. See [Snapshot] to find which threads differ.
+	ErrThreadsDiffer = errors.New("capability: threads have different capabilities")
+)
+
 // NewPid initializes a new [Capabilities] object for given pid when
 // it is nonzero, or for the current process if pid is 0.
 //
@@ -95,10 +179,25 @@ func NewPid(pid int) (Capabilities, error) {
 // it is nonzero, or for the current process if pid is 0. This
 // does not load the process's current capabilities; if needed,
 // call [Capabilities.Load].
+//
+// On Linux, the returned object implements [ProcessCapabilities].
 func NewPid2(pid int) (Capabilities, error) {
 	return newPid(pid)
 }
 
+// NewPidFromProcfs initializes a new [Capabilities] object for given pid,
+// which is loaded from the procfs mounted at procfs, such as the /proc of a
+// container, rather than from /proc. If pid is 0, the process reading
+// procfs is used. Unlike [NewPid2], all capability sets are loaded from
+// <procfs>/<pid>/status, so that pid can be in another PID namespace. The
+// securebits are not loaded, and the capabilities can not be applied. Use
+// [Capabilities.Load] to load the capabilities.
+//
+// On Linux, the returned object implements [ProcessCapabilities].
+func NewPidFromProcfs(procfs string, pid int) (Capabilities, error) {
+	return newPidFromProcfs(procfs, pid)
+}
+
 // NewFile initializes a new Capabilities object for given file path.
 //
 // Deprecated: replace with [NewFile2] followed by optional [Capabilities.Load]
@@ -131,10 +230,33 @@ func NewFile(path string) (Capabilities, error) {
 // NewFile2 creates a new initialized [Capabilities] object for given
 // file path. This does not load the process's current capabilities;
 // if needed, call [Capabilities.Load].
+//
+// On Linux, the returned object implements [FileCapabilities].
 func NewFile2(path string) (Capabilities, error) {
 	return newFile(path)
 }
 
+// NewFileFromFile creates a new [FileCapabilities] object for the given
+// open file. Unlike [NewFile2], it uses fgetxattr(2) and fsetxattr(2), so
+// it always refers to the same file, even if the file is concurrently
+// renamed or replaced. The file must not be closed while the object is in
+// use, and must not be nil. This does not load the file's current
+// capabilities; if needed, call [Capabilities.Load].
+func NewFileFromFile(f *os.File) (FileCapabilities, error) {
+	return newFileFromFile(f)
+}
+
+// NewFileFromBytes creates a new [FileCapabilities] object from the raw
+// value of the security.capability extended attribute, for example from the
+// "SCHILY.xattr.security.capability" PAX record of a tar header. An empty
+// value means that there are no file capabilities. The object is not
+// associated with a file; [Capabilities.Apply] updates the value returned by
+// [FileCapabilities.MarshalBinary], and [Capabilities.Load] restores the value
+// that was last applied.
+func NewFileFromBytes(data []byte) (FileCapabilities, error) {
+	return newFileFromBytes(data)
+}
+
 // LastCap returns highest valid capability of the running kernel,
 // or an error if it can not be obtained.
 //
@@ -174,3 +296,29 @@ func GetBound(c Cap) (bool, error) {
 func DropBound(caps ...Cap) error {
 	return dropBound(caps...)
 }
+
+// GetSecurebits returns the securebits of the calling thread.
+func GetSecurebits() (Securebits, error) {
+	return getSecurebits()
+}
+
+// SetSecurebits sets the securebits of the calling thread. This requires
+// CAP_SETPCAP in the effective set, and fails if any of the bits that are
+// changed is locked.
+func SetSecurebits(bits Securebits) error {
+	return setSecurebits(bits)
+}
+
+// GetNoNewPrivs determines if the no_new_privs flag is set for the calling
+// thread.
+func GetNoNewPrivs() (bool, error) {
+	return getNoNewPrivs()
+}
+
+// SetNoNewPrivs sets the no_new_privs flag for the calling thread, so that
+// execve(2) can no longer grant privileges, for example through setuid
+// binaries or file capabilities. Once set, the flag can not be unset, and
+// is inherited by children.
+func SetNoNewPrivs() error {
+	return setNoNewPrivs()
+}
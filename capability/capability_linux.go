@@ -8,11 +8,10 @@
 package capability
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -117,6 +116,16 @@ func newPid(pid int) (c Capabilities, retErr error) {
 	return
 }
 
+func newPidFromProcfs(procfs string, pid int) (Capabilities, error) {
+	if procfs == "" {
+		return nil, errors.New("procfs root must not be empty")
+	}
+	p := &capsV3{procfs: procfs}
+	p.hdr.version = linuxCapVer3
+	p.hdr.pid = int32(pid)
+	return p, nil
+}
+
 func ignoreEINVAL(err error) error {
 	if errors.Is(err, syscall.EINVAL) {
 		err = nil
@@ -129,6 +138,14 @@ type capsV3 struct {
 	data    [2]capData
 	bounds  [2]uint32
 	ambient [2]uint32
+
+	secbits    Securebits
+	secbitsOK  bool
+	noNewPrivs bool
+
+	// procfs is the procfs mount to load all capability sets from, instead
+	// of using capget(2), if not empty.
+	procfs string
 }
 
 func (c *capsV3) Get(which CapType, what Cap) bool {
@@ -291,51 +308,95 @@ func (c *capsV3) String() (ret string) {
 }
 
 func (c *capsV3) Load() (err error) {
-	err = capget(&c.hdr, &c.data[0])
-	if err != nil {
-		return
+	if c.procfs == "" {
+		err = capget(&c.hdr, &c.data[0])
+		if err != nil {
+			return
+		}
 	}
 
-	path := "/proc/self/status"
+	procfs, pid := c.procfs, "self"
+	if procfs == "" {
+		procfs = "/proc"
+	}
 	if c.hdr.pid != 0 {
-		path = fmt.Sprintf("/proc/%d/status", c.hdr.pid)
+		pid = strconv.Itoa(int(c.hdr.pid))
 	}
 
-	f, err := os.Open(path)
+	f, err := os.Open(filepath.Join(procfs, pid, "status"))
 	if err != nil {
 		return
 	}
-	b := bufio.NewReader(f)
-	for {
-		line, e := b.ReadString('\n')
-		if e != nil {
-			if e != io.EOF {
-				err = e
-			}
-			break
-		}
-		if val, ok := strings.CutPrefix(line, "CapBnd:\t"); ok {
-			_, err = fmt.Sscanf(val, "%08x%08x", &c.bounds[1], &c.bounds[0])
-			if err != nil {
-				break
-			}
-			continue
-		}
-		if val, ok := strings.CutPrefix(line, "CapAmb:\t"); ok {
-			_, err = fmt.Sscanf(val, "%08x%08x", &c.ambient[1], &c.ambient[0])
-			if err != nil {
-				break
-			}
-			continue
+	st, err := parseStatus(f)
+	f.Close()
+	if err != nil {
+		return
+	}
+	c.bounds = [2]uint32{uint32(st.state.Bounding), uint32(st.state.Bounding >> 32)}
+	c.ambient = [2]uint32{uint32(st.state.Ambient), uint32(st.state.Ambient >> 32)}
+	c.noNewPrivs = st.noNewPrivs
+	if c.procfs != "" {
+		st.state.CopyTo(c, CAPS)
+	}
+
+	// Securebits are not exposed in /proc/<pid>/status, and can only be
+	// obtained for the calling thread.
+	c.secbits, c.secbitsOK = 0, false
+	if c.hdr.pid == 0 && c.procfs == "" {
+		if bits, err := getSecurebits(); err == nil {
+			c.secbits, c.secbitsOK = bits, true
 		}
 	}
-	f.Close()
 
 	return
 }
 
+func (c *capsV3) Securebits() (Securebits, bool) {
+	return c.secbits, c.secbitsOK
+}
+
+func (c *capsV3) NoNewPrivs() bool {
+	return c.noNewPrivs
+}
+
 func (c *capsV3) Apply(kind CapType) error {
-	if c.hdr.pid != 0 {
+	return c.apply(kind, syscall.RawSyscall)
+}
+
+// allThreadsSupported reports whether [syscall.AllThreadsSyscall] can be
+// used, which is not the case if cgo is used.
+var allThreadsSupported = sync.OnceValue(func() bool {
+	_, _, e1 := syscall.AllThreadsSyscall(syscall.SYS_GETPID, 0, 0, 0)
+	return e1 != syscall.ENOTSUP
+})
+
+func (c *capsV3) ApplyAll(kind CapType) error {
+	if !allThreadsSupported() {
+		return ErrAllThreadsNotSupported
+	}
+	if c.hdr.pid != 0 || c.procfs != "" {
+		return errors.New("unable to modify capabilities of another process")
+	}
+	// AllThreadsSyscall aborts the program if the syscall succeeds on some
+	// threads and fails on others, which may happen if their capabilities
+	// or seccomp filters differ, so check they are the same beforehand.
+	p, err := snapshot(0)
+	if err != nil {
+		return err
+	}
+	leader := p.Leader()
+	for _, t := range p.Threads[1:] {
+		if t.State != leader.State || t.Seccomp != leader.Seccomp {
+			return fmt.Errorf("%w (thread %d)", ErrThreadsDiffer, t.TID)
+		}
+	}
+	return c.apply(kind, syscall.AllThreadsSyscall)
+}
+
+// apply implements Apply and ApplyAll, using sys to make the syscalls
+// that change capabilities.
+func (c *capsV3) apply(kind CapType, sys syscallFunc) error {
+	if c.hdr.pid != 0 || c.procfs != "" {
 		return errors.New("unable to modify capabilities of another process")
 	}
 	last, err := LastCap()
@@ -354,7 +415,7 @@ func (c *capsV3) Apply(kind CapType) error {
 					continue
 				}
 				// Ignore EINVAL since the capability may not be supported in this system.
-				err = ignoreEINVAL(dropBound(i))
+				err = ignoreEINVAL(prctlWith(sys, syscall.PR_CAPBSET_DROP, uintptr(i), 0))
 				if err != nil {
 					return err
 				}
@@ -363,7 +424,7 @@ func (c *capsV3) Apply(kind CapType) error {
 	}
 
 	if kind&CAPS == CAPS {
-		err = capset(&c.hdr, &c.data[0])
+		err = capsetWith(sys, &c.hdr, &c.data[0])
 		if err != nil {
 			return err
 		}
@@ -371,7 +432,7 @@ func (c *capsV3) Apply(kind CapType) error {
 
 	if kind&AMBS == AMBS {
 		// Ignore EINVAL as not supported on kernels before 4.3
-		err = ignoreEINVAL(resetAmbient())
+		err = ignoreEINVAL(prctlWith(sys, pr_CAP_AMBIENT, pr_CAP_AMBIENT_CLEAR_ALL, 0))
 		if err != nil {
 			return err
 		}
@@ -380,7 +441,7 @@ func (c *capsV3) Apply(kind CapType) error {
 				continue
 			}
 			// Ignore EINVAL as not supported on kernels before 4.3
-			err = ignoreEINVAL(setAmbient(true, i))
+			err = ignoreEINVAL(prctlWith(sys, pr_CAP_AMBIENT, pr_CAP_AMBIENT_RAISE, uintptr(i)))
 			if err != nil {
 				return err
 			}
@@ -434,14 +495,58 @@ func dropBound(caps ...Cap) error {
 	return nil
 }
 
+func getSecurebits() (Securebits, error) {
+	bits, err := prctlRetInt(syscall.PR_GET_SECUREBITS, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return Securebits(bits), nil
+}
+
+func setSecurebits(bits Securebits) error {
+	return prctl(syscall.PR_SET_SECUREBITS, uintptr(bits), 0)
+}
+
+func getNoNewPrivs() (bool, error) {
+	res, err := prctlRetInt(pr_GET_NO_NEW_PRIVS, 0, 0)
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func setNoNewPrivs() error {
+	return prctl(pr_SET_NO_NEW_PRIVS, 1, 0)
+}
+
 func newFile(path string) (c Capabilities, err error) {
 	c = &capsFile{path: path}
 	return
 }
 
+func newFileFromFile(f *os.File) (FileCapabilities, error) {
+	if f == nil {
+		return nil, errors.New("file must not be nil")
+	}
+	return &capsFile{file: f}, nil
+}
+
+func newFileFromBytes(data []byte) (FileCapabilities, error) {
+	c := &capsFile{fromBytes: true, raw: append([]byte(nil), data...)}
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// capsFile holds the capabilities of a file, identified either by path,
+// by file, or by the raw value of its security.capability xattr.
 type capsFile struct {
-	path string
-	data vfscapData
+	path      string
+	file      *os.File
+	fromBytes bool
+	raw       []byte
+	data      vfscapData
 }
 
 func (c *capsFile) Get(which CapType, what Cap) bool {
@@ -550,7 +655,7 @@ func (c *capsFile) Fill(kind CapType) {
 		c.data.effective[0] = 0xffffffff
 		c.data.data[0].permitted = 0xffffffff
 		c.data.data[0].inheritable = 0
-		if c.data.version == 2 {
+		if c.data.version >= 2 {
 			c.data.effective[1] = 0xffffffff
 			c.data.data[1].permitted = 0xffffffff
 			c.data.data[1].inheritable = 0
@@ -563,7 +668,7 @@ func (c *capsFile) Clear(kind CapType) {
 		c.data.effective[0] = 0
 		c.data.data[0].permitted = 0
 		c.data.data[0].inheritable = 0
-		if c.data.version == 2 {
+		if c.data.version >= 2 {
 			c.data.effective[1] = 0
 			c.data.data[1].permitted = 0
 			c.data.data[1].inheritable = 0
@@ -580,12 +685,52 @@ func (c *capsFile) String() (ret string) {
 }
 
 func (c *capsFile) Load() (err error) {
+	switch {
+	case c.file != nil:
+		return fgetVfsCap(c.file, &c.data)
+	case c.fromBytes:
+		if len(c.raw) == 0 {
+			c.data = vfscapData{version: 2}
+			return nil
+		}
+		return c.data.unmarshal(c.raw)
+	}
 	return getVfsCap(c.path, &c.data)
 }
 
 func (c *capsFile) Apply(kind CapType) (err error) {
 	if kind&CAPS == CAPS {
+		switch {
+		case c.file != nil:
+			return fsetVfsCap(c.file, &c.data)
+		case c.fromBytes:
+			c.raw, err = c.data.marshal()
+			return err
+		}
 		return setVfsCap(c.path, &c.data)
 	}
 	return
 }
+
+func (c *capsFile) MarshalBinary() ([]byte, error) {
+	return c.data.marshal()
+}
+
+func (c *capsFile) RootID() (int, bool) {
+	if c.data.version != 3 {
+		return 0, false
+	}
+	return int(c.data.rootid), true
+}
+
+func (c *capsFile) SetRootID(uid int) {
+	c.data.version = 3
+	c.data.rootid = uint32(uid)
+}
+
+func (c *capsFile) ClearRootID() {
+	if c.data.version == 3 {
+		c.data.version = 2
+	}
+	c.data.rootid = 0
+}
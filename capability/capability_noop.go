@@ -9,7 +9,10 @@
 
 package capability
 
-import "errors"
+import (
+	"errors"
+	"os"
+)
 
 var errNotSup = errors.New("not supported")
 
@@ -17,10 +20,38 @@ func newPid(_ int) (Capabilities, error) {
 	return nil, errNotSup
 }
 
+func newPidFromProcfs(_ string, _ int) (Capabilities, error) {
+	return nil, errNotSup
+}
+
 func newFile(_ string) (Capabilities, error) {
 	return nil, errNotSup
 }
 
+func getSecurebits() (Securebits, error) {
+	return 0, errNotSup
+}
+
+func setSecurebits(_ Securebits) error {
+	return errNotSup
+}
+
+func getNoNewPrivs() (bool, error) {
+	return false, errNotSup
+}
+
+func setNoNewPrivs() error {
+	return errNotSup
+}
+
+func newFileFromFile(_ *os.File) (FileCapabilities, error) {
+	return nil, errNotSup
+}
+
+func newFileFromBytes(_ []byte) (FileCapabilities, error) {
+	return nil, errNotSup
+}
+
 func lastCap() (Cap, error) {
 	return -1, errNotSup
 }
@@ -44,3 +75,7 @@ func getBound(_ Cap) (bool, error) {
 func dropBound(_ ...Cap) error {
 	return errNotSup
 }
+
+func snapshot(_ int) (*ProcessSnapshot, error) {
+	return nil, errNotSup
+}
@@ -5,10 +5,14 @@
 package capability_test
 
 import (
+	"bytes"
+	"errors"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -373,3 +377,655 @@ func childDropBound() {
 
 	os.Exit(0)
 }
+
+func TestFileCapsRootID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file capabilities are only supported on Linux")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("The test needs `CAP_SETFCAP`.")
+	}
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewFile2(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(FileCapabilities)
+	if _, ok := fc.RootID(); ok {
+		t.Fatal("RootID: want not namespaced")
+	}
+	fc.Set(PERMITTED|EFFECTIVE, CAP_NET_BIND_SERVICE)
+	fc.SetRootID(1000)
+	if err := fc.Apply(CAPS); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	c, err = NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc = c.(FileCapabilities)
+	if uid, ok := fc.RootID(); !ok || uid != 1000 {
+		t.Errorf("RootID: want 1000, got %d (%v)", uid, ok)
+	}
+	if !fc.Get(PERMITTED, CAP_NET_BIND_SERVICE) || !fc.Get(EFFECTIVE, CAP_NET_BIND_SERVICE) {
+		t.Errorf("want CAP_NET_BIND_SERVICE, got %s", fc)
+	}
+
+	fc.ClearRootID()
+	if err := fc.Apply(CAPS); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := fc.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fc.RootID(); ok {
+		t.Error("RootID: want not namespaced")
+	}
+}
+
+func TestNewFileFromBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := NewFileFromBytes(nil); err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+
+	// VFS_CAP_REVISION_3 with CAP_NET_BIND_SERVICE permitted and effective,
+	// and a rootid of 1000.
+	v3 := []byte{1, 0, 0, 3, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe8, 3, 0, 0}
+	c, err := NewFileFromBytes(v3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Get(PERMITTED, CAP_NET_BIND_SERVICE) || !c.Get(EFFECTIVE, CAP_NET_BIND_SERVICE) {
+		t.Errorf("want CAP_NET_BIND_SERVICE, got %s", c)
+	}
+	if uid, ok := c.RootID(); !ok || uid != 1000 {
+		t.Errorf("RootID: want 1000, got %d (%v)", uid, ok)
+	}
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v3) {
+		t.Errorf("MarshalBinary: want %x, got %x", v3, data)
+	}
+
+	// Apply updates the value that is restored by Load.
+	c.ClearRootID()
+	c.Set(PERMITTED, CAP_CHOWN)
+	if err := c.Apply(CAPS); err != nil {
+		t.Fatal(err)
+	}
+	c.Clear(CAPS)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Get(PERMITTED, CAP_CHOWN) {
+		t.Errorf("want CAP_CHOWN permitted, got %s", c)
+	}
+	if _, ok := c.RootID(); ok {
+		t.Error("RootID: want not namespaced")
+	}
+	data, err = c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewFileFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Get(PERMITTED, CAP_CHOWN) || !c.Get(PERMITTED, CAP_NET_BIND_SERVICE) {
+		t.Errorf("want CAP_CHOWN and CAP_NET_BIND_SERVICE permitted, got %s", c)
+	}
+
+	c, err = NewFileFromBytes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Empty(PERMITTED) || !c.Empty(EFFECTIVE) || !c.Empty(INHERITABLE) {
+		t.Errorf("want no capabilities, got %s", c)
+	}
+
+	for _, data := range [][]byte{{1, 2, 3}, v3[:20], append(v3, 0)} {
+		if _, err := NewFileFromBytes(data); err == nil {
+			t.Errorf("NewFileFromBytes(%x): want error, got nil", data)
+		}
+	}
+}
+
+func TestNewFileFromFile(t *testing.T) {
+	if _, err := NewFileFromFile(nil); err == nil {
+		t.Error("nil file: want error, got nil")
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if os.Getuid() != 0 {
+		t.Skip("The test needs `CAP_SETFCAP`.")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c, err := NewFileFromFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Empty(PERMITTED) {
+		t.Errorf("want no capabilities, got %s", c)
+	}
+
+	// The file is still referred to after it was renamed.
+	newPath := filepath.Join(dir, "renamed")
+	if err := os.Rename(path, newPath); err != nil {
+		t.Fatal(err)
+	}
+	c.Set(PERMITTED, CAP_NET_RAW)
+	if err := c.Apply(CAPS); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	pc, err := NewFile(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pc.Get(PERMITTED, CAP_NET_RAW) {
+		t.Errorf("want CAP_NET_RAW, got %s", pc)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Get(PERMITTED, CAP_NET_RAW) {
+		t.Errorf("want CAP_NET_RAW, got %s", c)
+	}
+}
+
+func TestStateOfCopyTo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process capabilities are only supported on Linux")
+	}
+	c, err := NewPid2(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	s := StateOf(c)
+	for which := EFFECTIVE; which <= AMBIENT; which <<= 1 {
+		for _, cp := range ListKnown() {
+			var set Set
+			switch which {
+			case EFFECTIVE:
+				set = s.Effective
+			case PERMITTED:
+				set = s.Permitted
+			case INHERITABLE:
+				set = s.Inheritable
+			case BOUNDING:
+				set = s.Bounding
+			case AMBIENT:
+				set = s.Ambient
+			}
+			if set.Has(cp) != c.Get(which, cp) {
+				t.Errorf("StateOf: %s of %s: want %v", which, cp, c.Get(which, cp))
+			}
+		}
+	}
+
+	want := State{Effective: NewSet(CAP_CHOWN), Inheritable: NewSet(CAP_KILL, CAP_SETPCAP)}
+	want.CopyTo(c, EFFECTIVE|INHERITABLE)
+	got := StateOf(c)
+	if got.Effective != want.Effective || got.Inheritable != want.Inheritable {
+		t.Errorf("CopyTo: want %+v, got %+v", want, got)
+	}
+	if got.Permitted != s.Permitted || got.Bounding != s.Bounding {
+		t.Errorf("CopyTo: other sets should not change, want %+v, got %+v", s, got)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	a := NewSet(CAP_CHOWN, CAP_KILL, CAP_SYS_ADMIN)
+	b := NewSet(CAP_KILL, CAP_NET_RAW)
+
+	if got, want := a.Union(b), NewSet(CAP_CHOWN, CAP_KILL, CAP_NET_RAW, CAP_SYS_ADMIN); got != want {
+		t.Errorf("Union: want %s, got %s", want, got)
+	}
+	if got, want := a.Intersect(b), NewSet(CAP_KILL); got != want {
+		t.Errorf("Intersect: want %s, got %s", want, got)
+	}
+	if got, want := a.Difference(b), NewSet(CAP_CHOWN, CAP_SYS_ADMIN); got != want {
+		t.Errorf("Difference: want %s, got %s", want, got)
+	}
+	for _, tc := range []struct {
+		s, other Set
+		want     bool
+	}{
+		{s: a, other: b, want: false},
+		{s: NewSet(CAP_KILL), other: b, want: true},
+		{s: b, other: b, want: true},
+		{s: Set(0), other: b, want: true},
+		{s: b, other: Set(0), want: false},
+	} {
+		if got := tc.s.IsSubset(tc.other); got != tc.want {
+			t.Errorf("(%s).IsSubset(%s): want %v, got %v", tc.s, tc.other, tc.want, got)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	policy := State{
+		Effective: NewSet(CAP_CHOWN, CAP_KILL),
+		Bounding:  NewSet(CAP_CHOWN, CAP_KILL),
+	}
+	process := State{
+		Effective: NewSet(CAP_KILL, CAP_SYS_ADMIN),
+		Bounding:  NewSet(CAP_CHOWN, CAP_KILL),
+		Ambient:   NewSet(CAP_NET_RAW),
+	}
+
+	d := policy.Diff(process)
+	want := StateDiff{
+		Added:   State{Effective: NewSet(CAP_SYS_ADMIN), Ambient: NewSet(CAP_NET_RAW)},
+		Removed: State{Effective: NewSet(CAP_CHOWN)},
+	}
+	if d != want {
+		t.Errorf("Diff: want %+v, got %+v", want, d)
+	}
+	if d.Empty() {
+		t.Error("Empty: want false, got true")
+	}
+	if got, want := d.String(), "effective: +sys_admin, -chown; ambient: +net_raw"; got != want {
+		t.Errorf("String: want %q, got %q", want, got)
+	}
+	if d := process.Diff(process); !d.Empty() || d.String() != "" {
+		t.Errorf("Diff with itself: want no differences, got %q", d)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	a, err := NewPid2(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPid2(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.CopyTo(a, CAPS|BOUNDS|AMBS)
+	process.CopyTo(b, CAPS|BOUNDS|AMBS)
+	if got := Diff(a, b); got != want {
+		t.Errorf("Diff: want %+v, got %+v", want, got)
+	}
+}
+
+func TestSecurebits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := GetSecurebits(); err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+
+	requirePCapSet(t)
+	out := testInChild(t, childSecurebits)
+	t.Logf("output from child:\n%s", out)
+}
+
+func childSecurebits() {
+	runtime.LockOSThread()
+	log.SetFlags(log.Lshortfile)
+
+	bits, err := GetSecurebits()
+	if err != nil {
+		log.Fatalf("GetSecurebits: %v", err)
+	}
+	want := bits | SECBIT_NO_CAP_AMBIENT_RAISE | SECBIT_NO_CAP_AMBIENT_RAISE_LOCKED
+	if err := SetSecurebits(want); err != nil {
+		log.Fatalf("SetSecurebits(%s): %v", want, err)
+	}
+	bits, err = GetSecurebits()
+	if err != nil {
+		log.Fatalf("GetSecurebits: %v", err)
+	}
+	if bits != want {
+		log.Fatalf("GetSecurebits: want %s, got %s", want, bits)
+	}
+	if err := SetSecurebits(bits &^ SECBIT_NO_CAP_AMBIENT_RAISE); err == nil {
+		log.Fatal("SetSecurebits: want error changing a locked bit, got nil")
+	}
+
+	c, err := NewPid2(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if got, ok := c.(ProcessCapabilities).Securebits(); !ok || got != want {
+		log.Fatalf("Securebits: want %s, got %s (%v)", want, got, ok)
+	}
+
+	os.Exit(0)
+}
+
+func TestNoNewPrivs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := GetNoNewPrivs(); err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+
+	out := testInChild(t, childNoNewPrivs)
+	t.Logf("output from child:\n%s", out)
+}
+
+func childNoNewPrivs() {
+	runtime.LockOSThread()
+	log.SetFlags(log.Lshortfile)
+
+	if err := SetNoNewPrivs(); err != nil {
+		log.Fatalf("SetNoNewPrivs: %v", err)
+	}
+	set, err := GetNoNewPrivs()
+	if err != nil {
+		log.Fatalf("GetNoNewPrivs: %v", err)
+	}
+	if !set {
+		log.Fatal("GetNoNewPrivs: want true, got false")
+	}
+
+	c, err := NewPid2(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if !c.(ProcessCapabilities).NoNewPrivs() {
+		log.Fatal("NoNewPrivs: want true, got false")
+	}
+
+	os.Exit(0)
+}
+
+func TestApplyAll(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	requirePCapSet(t)
+	out := testInChild(t, childApplyAll)
+	t.Logf("output from child:\n%s", out)
+}
+
+func childApplyAll() {
+	log.SetFlags(log.Lshortfile)
+
+	// Make sure there are other threads than the calling one, some of
+	// which are locked to a goroutine.
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < 4; i++ {
+		go func() {
+			runtime.LockOSThread()
+			<-stop
+		}()
+	}
+
+	c, err := NewPid2(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+	c.Unset(EFFECTIVE, CAP_NET_RAW)
+	err = c.(ProcessCapabilities).ApplyAll(CAPS)
+	if errors.Is(err, ErrAllThreadsNotSupported) {
+		log.Print("skipping: ", err)
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("ApplyAll: %v", err)
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(tasks) < 2 {
+		log.Fatalf("want more than one thread, got %d", len(tasks))
+	}
+	for _, task := range tasks {
+		status, err := os.ReadFile("/proc/self/task/" + task.Name() + "/status")
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range strings.Split(string(status), "\n") {
+			v, ok := strings.CutPrefix(line, "CapEff:\t")
+			if !ok {
+				continue
+			}
+			eff, err := strconv.ParseUint(v, 16, 64)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if eff&(1<<uint(CAP_NET_RAW)) != 0 {
+				log.Fatalf("thread %s: want %s dropped, got CapEff %s", task.Name(), CAP_NET_RAW, v)
+			}
+		}
+	}
+
+	os.Exit(0)
+}
+
+func TestApplyAllThreadsDiffer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	requirePCapSet(t)
+	out := testInChild(t, childApplyAllThreadsDiffer)
+	t.Logf("output from child:\n%s", out)
+}
+
+func childApplyAllThreadsDiffer() {
+	log.SetFlags(log.Lshortfile)
+
+	// Drop CAP_NET_RAW from a single thread, other than the leader, using
+	// Apply. Threads are locked and never unlocked, so that they are not
+	// reused.
+	tid := make(chan int)
+	dropTID := os.Getpid()
+	for dropTID == os.Getpid() {
+		go func() {
+			runtime.LockOSThread()
+			self, err := os.Readlink("/proc/thread-self")
+			if err != nil {
+				log.Fatal(err)
+			}
+			id, err := strconv.Atoi(filepath.Base(self))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if id != os.Getpid() {
+				c, err := NewPid2(0)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := c.Load(); err != nil {
+					log.Fatal(err)
+				}
+				c.Unset(EFFECTIVE|PERMITTED, CAP_NET_RAW)
+				if err := c.Apply(CAPS); err != nil {
+					log.Fatalf("Apply: %v", err)
+				}
+			}
+			tid <- id
+			select {}
+		}()
+		dropTID = <-tid
+	}
+
+	// Raising CAP_NET_RAW again would succeed on the other threads and
+	// fail on the one that dropped it, which must be detected beforehand.
+	c, err := NewPid2(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+	c.Set(EFFECTIVE|PERMITTED, CAP_NET_RAW)
+	err = c.(ProcessCapabilities).ApplyAll(CAPS)
+	if errors.Is(err, ErrAllThreadsNotSupported) {
+		log.Print("skipping: ", err)
+		os.Exit(0)
+	}
+	if !errors.Is(err, ErrThreadsDiffer) {
+		log.Fatalf("ApplyAll: want %v, got %v", ErrThreadsDiffer, err)
+	}
+	log.Print(err)
+
+	os.Exit(0)
+}
+
+func TestSnapshot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := Snapshot(0); err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+
+	out := testInChild(t, childSnapshot)
+	t.Logf("output from child:\n%s", out)
+}
+
+func childSnapshot() {
+	log.SetFlags(log.Lshortfile)
+
+	// Set no_new_privs for a single thread, other than the leader. Threads
+	// are locked and never unlocked, so that they are not reused.
+	tid := make(chan int)
+	nnpTID := os.Getpid()
+	for nnpTID == os.Getpid() {
+		go func() {
+			runtime.LockOSThread()
+			self, err := os.Readlink("/proc/thread-self")
+			if err != nil {
+				log.Fatal(err)
+			}
+			id, err := strconv.Atoi(filepath.Base(self))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if id != os.Getpid() {
+				if err := SetNoNewPrivs(); err != nil {
+					log.Fatalf("SetNoNewPrivs: %v", err)
+				}
+			}
+			tid <- id
+			select {}
+		}()
+		nnpTID = <-tid
+	}
+
+	c, err := NewPid2(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	p, err := Snapshot(0)
+	if err != nil {
+		log.Fatalf("Snapshot: %v", err)
+	}
+	if p.PID != os.Getpid() {
+		log.Fatalf("PID: want %d, got %d", os.Getpid(), p.PID)
+	}
+	if len(p.Threads) < 2 {
+		log.Fatalf("want more than one thread, got %d", len(p.Threads))
+	}
+	leader := p.Leader()
+	if leader.TID != p.PID {
+		log.Fatalf("Leader: want TID %d, got %d", p.PID, leader.TID)
+	}
+	if want := StateOf(c); leader.State.Bounding != want.Bounding || leader.State.Permitted != want.Permitted {
+		log.Fatalf("Leader: want %+v, got %+v", want, leader.State)
+	}
+	if leader.NoNewPrivs || leader.Differs {
+		log.Fatalf("Leader: want no_new_privs unset, got %+v", leader)
+	}
+	found := false
+	for _, th := range p.Threads {
+		if th.TID == nnpTID {
+			found = true
+			if !th.NoNewPrivs || !th.Differs {
+				log.Fatalf("thread %d: want no_new_privs set and differing, got %+v", th.TID, th)
+			}
+		} else if th.Differs {
+			log.Fatalf("thread %d: want no differences, got %+v", th.TID, th)
+		}
+	}
+	if !found {
+		log.Fatalf("thread %d not found", nnpTID)
+	}
+	if !p.Differs() {
+		log.Fatal("Differs: want true, got false")
+	}
+
+	os.Exit(0)
+}
+
+func TestSeccompModeString(t *testing.T) {
+	for _, tc := range []struct {
+		mode SeccompMode
+		want string
+	}{
+		{SeccompDisabled, "disabled"},
+		{SeccompStrict, "strict"},
+		{SeccompFilter, "filter"},
+		{SeccompMode(3), "unknown (3)"},
+	} {
+		if got := tc.mode.String(); got != tc.want {
+			t.Errorf("%d: want %q, got %q", int(tc.mode), tc.want, got)
+		}
+	}
+}
+
+func TestSecurebitsString(t *testing.T) {
+	for _, tc := range []struct {
+		bits Securebits
+		want string
+	}{
+		{0, ""},
+		{SECBIT_NOROOT | SECBIT_NOROOT_LOCKED, "noroot, noroot_locked"},
+		{SECBIT_KEEP_CAPS | 1<<8, "keep_caps, 0x100"},
+	} {
+		if got := tc.bits.String(); got != tc.want {
+			t.Errorf("%#x: want %q, got %q", uint32(tc.bits), tc.want, got)
+		}
+	}
+}
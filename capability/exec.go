@@ -0,0 +1,135 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import "os/exec"
+
+// Cmd is a command that is started with a prescribed capability state.
+//
+// The capabilities can not be changed between fork and exec in Go, so the
+// command is started by re-executing the current binary, using the
+// [github.com/moby/sys/reexec] package, which changes its capabilities and
+// then executes the command. Programs using Cmd must therefore:
+//
+//   - call [RegisterCommand] from an init function, or at the start of
+//     main, before calling [github.com/moby/sys/reexec.Init]; and
+//   - call [github.com/moby/sys/reexec.Init] at the start of main, and
+//     return if it returns true.
+//
+// Otherwise, [Cmd.Start] returns an error.
+//
+// For example:
+//
+//	func main() {
+//		capability.RegisterCommand()
+//		if reexec.Init() {
+//			return
+//		}
+//		...
+//	}
+//
+// In the re-executed process, the following steps are performed, in this
+// order:
+//
+//  1. Capabilities that are not in State.Bounding are dropped from the
+//     bounding set. This requires CAP_SETPCAP, unless these capabilities
+//     are not in the bounding set already.
+//  2. The effective, permitted and inheritable sets are set to those of
+//     State, using capset(2).
+//  3. The ambient set is cleared, and the capabilities in State.Ambient are
+//     raised. These must be in both the permitted and inheritable sets.
+//  4. If Securebits is not zero, the securebits are set. This requires
+//     CAP_SETPCAP in State.Effective.
+//  5. If NoNewPrivs is true, the no_new_privs flag is set.
+//  6. The command is executed.
+//
+// If any of these steps fails, [Cmd.Start] and [Cmd.Run] return an
+// [*ExecError] identifying the step.
+//
+// Cmd is only supported on Linux.
+type Cmd struct {
+	// Cmd is the command to start. Its Path, Args, Env, Dir, standard I/O,
+	// ExtraFiles and SysProcAttr are used as-is for the command, except
+	// that the file descriptor following those in ExtraFiles is used
+	// internally until the command is executed. Cmd must not be started
+	// directly.
+	Cmd *exec.Cmd
+
+	// State is the capability state to start the command with. Note that
+	// the capabilities of the command are recomputed by execve(2); see
+	// capabilities(7) for details. Use [StateOf] to start from the state
+	// of the current process.
+	State State
+
+	// Securebits, if not zero, are set before executing the command.
+	Securebits Securebits
+
+	// NoNewPrivs sets the no_new_privs flag before executing the command.
+	NoNewPrivs bool
+}
+
+// Command returns a [*Cmd] to execute the named program with the given
+// arguments, similar to [exec.Command]. The capability state of the
+// command is empty, which drops all capabilities, unless State is set.
+//
+// [RegisterCommand] must be called, and [github.com/moby/sys/reexec.Init]
+// must be called at the start of main, to use the returned command; see
+// [Cmd] for details.
+func Command(name string, arg ...string) *Cmd {
+	return &Cmd{Cmd: exec.Command(name, arg...)}
+}
+
+// RegisterCommand registers the [github.com/moby/sys/reexec] entrypoint
+// that is used by [Cmd] to change the capabilities of the command before
+// executing it. It must be called in the program using Cmd before
+// [github.com/moby/sys/reexec.Init] is called, typically from an init
+// function or at the start of main. The entrypoint is named
+// "moby-sys-capability-exec". Calling RegisterCommand more than once is a
+// no-op.
+func RegisterCommand() {
+	registerCommand()
+}
+
+// Start starts the command, after changing its capabilities. Unlike
+// [exec.Cmd.Start], it waits for the command to be executed, so that an
+// [*ExecError] can be returned if changing the capabilities or executing
+// the command fails.
+func (c *Cmd) Start() error {
+	return c.start()
+}
+
+// Wait waits for the command to exit. See [exec.Cmd.Wait].
+func (c *Cmd) Wait() error {
+	return c.Cmd.Wait()
+}
+
+// Run starts the command and waits for it to complete.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// ExecError is returned by [Cmd.Start] and [Cmd.Run] if one of the steps
+// performed before executing the command fails.
+type ExecError struct {
+	// Step is the step that failed: "bounding", "capset", "ambient",
+	// "securebits", "no_new_privs" or "exec".
+	Step string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ExecError) Error() string {
+	return "capability: " + e.Step + " failed: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/moby/sys/reexec"
+)
+
+// execEntrypoint is the name of the reexec entrypoint used by Cmd.
+const execEntrypoint = "moby-sys-capability-exec"
+
+var (
+	registerOnce sync.Once
+	registered   atomic.Bool
+)
+
+func registerCommand() {
+	registerOnce.Do(func() {
+		reexec.Register(execEntrypoint, execMain)
+		registered.Store(true)
+	})
+}
+
+func (c *Cmd) start() error {
+	cmd := c.Cmd
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	if !registered.Load() {
+		return errors.New("capability: RegisterCommand must be called to use Cmd")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Start the current binary instead, passing the capability state, the
+	// error pipe and the command as arguments, and restore the command
+	// once started.
+	path, args, extraFiles := cmd.Path, cmd.Args, cmd.ExtraFiles
+	if len(args) == 0 {
+		args = []string{path}
+	}
+	s := c.State
+	nnp := 0
+	if c.NoNewPrivs {
+		nnp = 1
+	}
+	config := fmt.Sprintf("%x:%x:%x:%x:%x:%x:%d", uint64(s.Effective), uint64(s.Permitted),
+		uint64(s.Inheritable), uint64(s.Bounding), uint64(s.Ambient), uint32(c.Securebits), nnp)
+	cmd.Path = reexec.Self()
+	cmd.Args = append([]string{execEntrypoint, strconv.Itoa(3 + len(extraFiles)), config, path}, args...)
+	cmd.ExtraFiles = append(extraFiles[:len(extraFiles):len(extraFiles)], w)
+	err = cmd.Start()
+	cmd.Path, cmd.Args, cmd.ExtraFiles = path, args, extraFiles
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	// The pipe is closed without any data written to it once the command
+	// is executed.
+	msg, err := io.ReadAll(r)
+	if err == nil && len(msg) == 0 {
+		return nil
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	if err != nil {
+		return err
+	}
+	return decodeExecError(msg)
+}
+
+// execMain is the reexec entrypoint of Cmd. It is called with the file
+// descriptor of the error pipe, the capability state, the path of the
+// command, and its arguments.
+func execMain() {
+	// Capabilities are per-thread, so the thread that changes them must be
+	// the one executing the command.
+	runtime.LockOSThread()
+
+	if len(os.Args) < 5 {
+		fmt.Fprintln(os.Stderr, execEntrypoint+": invalid arguments")
+		os.Exit(1)
+	}
+	fd, err := strconv.Atoi(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, execEntrypoint+": invalid arguments")
+		os.Exit(1)
+	}
+	pipe := os.NewFile(uintptr(fd), "error pipe")
+	syscall.CloseOnExec(fd)
+
+	step, err := execWithCaps(os.Args[2], os.Args[3], os.Args[4:])
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		fmt.Fprintf(pipe, "%s\nerrno %d", step, uintptr(errno))
+	} else {
+		fmt.Fprintf(pipe, "%s\n%v", step, err)
+	}
+	os.Exit(1)
+}
+
+// execWithCaps sets the capability state described by config and executes
+// path. It only returns if that fails, with the step that failed.
+func execWithCaps(config, path string, args []string) (step string, _ error) {
+	var (
+		s          State
+		securebits Securebits
+		nnp        int
+	)
+	_, err := fmt.Sscanf(config, "%x:%x:%x:%x:%x:%x:%d", (*uint64)(&s.Effective), (*uint64)(&s.Permitted),
+		(*uint64)(&s.Inheritable), (*uint64)(&s.Bounding), (*uint64)(&s.Ambient), (*uint32)(&securebits), &nnp)
+	if err != nil {
+		return "exec", fmt.Errorf("invalid capability state %q: %w", config, err)
+	}
+
+	last, err := LastCap()
+	if err != nil {
+		return "bounding", err
+	}
+	for i := Cap(0); i <= last; i++ {
+		if s.Bounding.Has(i) {
+			continue
+		}
+		if ok, err := getBound(i); err != nil {
+			return "bounding", err
+		} else if !ok {
+			continue
+		}
+		if err := dropBound(i); err != nil {
+			return "bounding", err
+		}
+	}
+
+	c, err := newPid(0)
+	if err != nil {
+		return "capset", err
+	}
+	s.CopyTo(c, CAPS)
+	if err := c.Apply(CAPS); err != nil {
+		return "capset", err
+	}
+
+	err = resetAmbient()
+	if s.Ambient == 0 {
+		// Ignore EINVAL as not supported on kernels before 4.3
+		err = ignoreEINVAL(err)
+	}
+	if err != nil {
+		return "ambient", err
+	}
+	if err := setAmbient(true, s.Ambient.List()...); err != nil {
+		return "ambient", err
+	}
+
+	if securebits != 0 {
+		if err := setSecurebits(securebits); err != nil {
+			return "securebits", err
+		}
+	}
+	if nnp != 0 {
+		if err := setNoNewPrivs(); err != nil {
+			return "no_new_privs", err
+		}
+	}
+
+	return "exec", syscall.Exec(path, args, os.Environ())
+}
+
+func decodeExecError(msg []byte) error {
+	step, text, _ := strings.Cut(string(msg), "\n")
+	if v, ok := strings.CutPrefix(text, "errno "); ok {
+		if errno, err := strconv.ParseUint(v, 10, 0); err == nil {
+			return &ExecError{Step: step, Err: syscall.Errno(errno)}
+		}
+	}
+	return &ExecError{Step: step, Err: errors.New(text)}
+}
@@ -0,0 +1,15 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package capability
+
+func registerCommand() {}
+
+func (c *Cmd) start() error {
+	return errNotSup
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	. "github.com/moby/sys/capability"
+	"github.com/moby/sys/reexec"
+)
+
+func TestMain(m *testing.M) {
+	RegisterCommand()
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func currentState(t *testing.T) State {
+	t.Helper()
+	c, err := NewPid2(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return StateOf(c)
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if err := Command("true").Run(); err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+
+	requirePCapSet(t)
+	caps := NewSet(CAP_CHOWN, CAP_NET_RAW)
+	cmd := Command("/bin/sh", "-c", "grep -E '^Cap(Bnd|Inh)' /proc/self/status")
+	cmd.State = State{
+		Effective:   caps,
+		Permitted:   caps,
+		Inheritable: caps,
+		Bounding:    caps,
+	}
+	var out bytes.Buffer
+	cmd.Cmd.Stdout = &out
+	cmd.Cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run: %v\n%s", err, out.Bytes())
+	}
+	want := fmt.Sprintf("CapInh:\t%016x\nCapBnd:\t%016x\n", uint64(caps), uint64(caps))
+	if got := out.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	for _, tc := range []struct {
+		name string
+		cmd  func(State) *Cmd
+		step string
+		err  error
+	}{
+		{
+			name: "exec",
+			cmd: func(s State) *Cmd {
+				cmd := Command("/nonexistent")
+				cmd.State = s
+				return cmd
+			},
+			step: "exec",
+			err:  syscall.ENOENT,
+		},
+		{
+			name: "securebits",
+			cmd: func(s State) *Cmd {
+				cmd := Command("/bin/true")
+				cmd.State = s
+				cmd.State.Effective.Remove(CAP_SETPCAP)
+				cmd.Securebits = SECBIT_NOROOT
+				return cmd
+			},
+			step: "securebits",
+			err:  syscall.EPERM,
+		},
+		{
+			name: "ambient",
+			cmd: func(s State) *Cmd {
+				cmd := Command("/bin/true")
+				cmd.State = s
+				cmd.State.Inheritable.Remove(CAP_CHOWN)
+				cmd.State.Ambient.Add(CAP_CHOWN)
+				return cmd
+			},
+			step: "ambient",
+			err:  syscall.EPERM,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cmd(currentState(t)).Run()
+			var execErr *ExecError
+			if !errors.As(err, &execErr) {
+				t.Fatalf("want *ExecError, got %v", err)
+			}
+			if execErr.Step != tc.step || !errors.Is(err, tc.err) {
+				t.Errorf("want %s step to fail with %v, got %v", tc.step, tc.err, err)
+			}
+		})
+	}
+}
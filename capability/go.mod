@@ -1,3 +1,10 @@
 module github.com/moby/sys/capability
 
 go 1.21
+
+require (
+	github.com/moby/sys/reexec v0.1.0
+	github.com/moby/sys/user v0.4.0
+)
+
+require golang.org/x/sys v0.1.0 // indirect
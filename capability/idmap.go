@@ -0,0 +1,82 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || windows
+
+package capability
+
+import (
+	"fmt"
+
+	"github.com/moby/sys/user"
+)
+
+// FileCapsToHost converts the file capabilities c, as seen in a container
+// using the given identity mapping, to the file capabilities to store on the
+// host. Non-namespaced file capabilities become namespaced, with the rootid
+// set to the host user ID of the container's root user. The rootid of
+// namespaced file capabilities is mapped to the host.
+//
+// It is a no-op if idmap is empty. The change takes effect on
+// [Capabilities.Apply].
+//
+// FileCapsToHost and [FileCapsToContainer] are only available on the
+// platforms supported by [github.com/moby/sys/user].
+func FileCapsToHost(c FileCapabilities, idmap user.IdentityMapping) error {
+	if idmap.Empty() {
+		return nil
+	}
+	uid, _ := c.RootID()
+	hostUID, err := mapUID(uid, idmap.UIDMaps, true)
+	if err != nil {
+		return err
+	}
+	setRootID(c, hostUID)
+	return nil
+}
+
+// FileCapsToContainer is the reverse of [FileCapsToHost]. It maps the rootid
+// of namespaced file capabilities c to the container using the given identity
+// mapping. If the rootid maps to the container's root user, the file
+// capabilities become non-namespaced.
+//
+// It is a no-op if c is not namespaced, or if idmap is empty. The change takes
+// effect on [Capabilities.Apply].
+func FileCapsToContainer(c FileCapabilities, idmap user.IdentityMapping) error {
+	uid, ok := c.RootID()
+	if !ok || idmap.Empty() {
+		return nil
+	}
+	contUID, err := mapUID(uid, idmap.UIDMaps, false)
+	if err != nil {
+		return err
+	}
+	setRootID(c, contUID)
+	return nil
+}
+
+func setRootID(c FileCapabilities, uid int) {
+	if uid == 0 {
+		c.ClearRootID()
+	} else {
+		c.SetRootID(uid)
+	}
+}
+
+// mapUID maps uid from the container to the host if toHost is set, or from
+// the host to the container otherwise.
+func mapUID(uid int, maps []user.IDMap, toHost bool) (int, error) {
+	for _, m := range maps {
+		from, to := m.ID, m.ParentID
+		if !toHost {
+			from, to = to, from
+		}
+		if id := int64(uid); id >= from && id < from+m.Count {
+			return int(to + id - from), nil
+		}
+	}
+	return -1, fmt.Errorf("rootid %d is not mapped", uid)
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || windows
+
+package capability_test
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/moby/sys/capability"
+	"github.com/moby/sys/user"
+)
+
+func TestFileCapsIDMap(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file capabilities are only supported on Linux")
+	}
+	c, err := NewFile2(filepath.Join(t.TempDir(), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(FileCapabilities)
+	idmap := user.IdentityMapping{
+		UIDMaps: []user.IDMap{{ID: 0, ParentID: 100000, Count: 65536}},
+		GIDMaps: []user.IDMap{{ID: 0, ParentID: 100000, Count: 65536}},
+	}
+
+	for _, tc := range []struct {
+		op      string
+		fn      func(FileCapabilities, user.IdentityMapping) error
+		rootID  int // -1 if not namespaced
+		want    int
+		wantErr bool
+	}{
+		{op: "FileCapsToHost", fn: FileCapsToHost, rootID: -1, want: 100000},
+		{op: "FileCapsToHost", fn: FileCapsToHost, rootID: 1000, want: 101000},
+		{op: "FileCapsToHost", fn: FileCapsToHost, rootID: 65536, wantErr: true},
+		{op: "FileCapsToContainer", fn: FileCapsToContainer, rootID: -1, want: -1},
+		{op: "FileCapsToContainer", fn: FileCapsToContainer, rootID: 100000, want: -1},
+		{op: "FileCapsToContainer", fn: FileCapsToContainer, rootID: 101000, want: 1000},
+		{op: "FileCapsToContainer", fn: FileCapsToContainer, rootID: 1000, wantErr: true},
+	} {
+		if tc.rootID < 0 {
+			fc.ClearRootID()
+		} else {
+			fc.SetRootID(tc.rootID)
+		}
+		err := tc.fn(fc, idmap)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s(%d): want error, got nil", tc.op, tc.rootID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s(%d): %v", tc.op, tc.rootID, err)
+			continue
+		}
+		uid, ok := fc.RootID()
+		if !ok {
+			uid = -1
+		}
+		if uid != tc.want {
+			t.Errorf("%s(%d): want rootid %d, got %d", tc.op, tc.rootID, tc.want, uid)
+		}
+	}
+
+	// An empty mapping is a no-op.
+	fc.SetRootID(1000)
+	if err := FileCapsToHost(fc, user.IdentityMapping{}); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := fc.RootID(); uid != 1000 {
+		t.Errorf("FileCapsToHost: want rootid 1000, got %d", uid)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// UnknownCapPolicy defines how [UnmarshalState] handles unknown
+// capabilities, which are capabilities that are either not known to this
+// package, or not supported by the running kernel, as reported by
+// [LastCap].
+type UnknownCapPolicy int
+
+const (
+	// UnknownCapError returns an error for unknown capabilities.
+	UnknownCapError UnknownCapPolicy = iota
+	// UnknownCapIgnore silently drops unknown capabilities.
+	UnknownCapIgnore
+	// UnknownCapKeep keeps unknown capabilities by number. Names that are
+	// not known to this package can not be kept, and are still an error.
+	UnknownCapKeep
+)
+
+// stateJSON is the JSON representation of a State, which is the same as
+// the capabilities of a process in the OCI runtime specification.
+type stateJSON struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// MarshalJSON implements [json.Marshaler]. The capability sets are encoded
+// as an object with "bounding", "effective", "inheritable", "permitted" and
+// "ambient" arrays of capability names such as "CAP_NET_RAW", as in the
+// OCI runtime specification. Empty sets are omitted. Capabilities that are
+// not known to this package are encoded by number.
+//
+// To encode [Capabilities], use [StateOf]. For [FileCapabilities], use
+// [FileStateOf], which keeps the effective flag and the rootid.
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(stateJSON{
+		Bounding:    s.Bounding.names(),
+		Effective:   s.Effective.names(),
+		Inheritable: s.Inheritable.names(),
+		Permitted:   s.Permitted.names(),
+		Ambient:     s.Ambient.names(),
+	})
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It accepts the format
+// produced by [State.MarshalJSON], with capabilities given in any form
+// accepted by [ParseCap]. It is equivalent to [UnmarshalState] with
+// [UnknownCapKeep], except that it does not depend on the running kernel:
+// capabilities not supported by the kernel are kept.
+//
+// To decode into [Capabilities], use [State.CopyTo].
+func (s *State) UnmarshalJSON(data []byte) error {
+	st, err := unmarshalState(data, UnknownCapKeep, maxCap)
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
+
+// UnmarshalState decodes capability sets in the format produced by
+// [State.MarshalJSON], handling unknown capabilities according to policy.
+func UnmarshalState(data []byte, policy UnknownCapPolicy) (State, error) {
+	last, err := LastCap()
+	if err != nil {
+		// Not supported on this platform; only consider the capabilities
+		// known to this package.
+		last = maxCap
+	}
+	return unmarshalState(data, policy, last)
+}
+
+func unmarshalState(data []byte, policy UnknownCapPolicy, last Cap) (State, error) {
+	var (
+		j stateJSON
+		s State
+	)
+	if err := json.Unmarshal(data, &j); err != nil {
+		return s, err
+	}
+	for _, f := range []struct {
+		which CapType
+		names []string
+	}{
+		{BOUNDING, j.Bounding},
+		{EFFECTIVE, j.Effective},
+		{INHERITABLE, j.Inheritable},
+		{PERMITTED, j.Permitted},
+		{AMBIENT, j.Ambient},
+	} {
+		set, err := parseNames(f.which, f.names, policy, last)
+		if err != nil {
+			return State{}, err
+		}
+		*s.set(f.which) = set
+	}
+	return s, nil
+}
+
+// parseNames parses the names of the capabilities in the set which,
+// handling unknown capabilities according to policy.
+func parseNames(which CapType, names []string, policy UnknownCapPolicy, last Cap) (Set, error) {
+	var set Set
+	for _, name := range names {
+		c, err := ParseCap(name)
+		if err != nil {
+			if policy == UnknownCapIgnore {
+				continue
+			}
+			return 0, fmt.Errorf("%s set: %w", which, err)
+		}
+		if c > last || c.String() == "unknown" {
+			switch policy {
+			case UnknownCapError:
+				return 0, fmt.Errorf("%s set: unknown capability: %q", which, name)
+			case UnknownCapIgnore:
+				continue
+			}
+		}
+		set.Add(c)
+	}
+	return set, nil
+}
+
+// fileStateJSON is the JSON representation of a FileState.
+type fileStateJSON struct {
+	Effective   bool     `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	RootID      *int     `json:"rootid,omitempty"`
+}
+
+// MarshalJSON implements [json.Marshaler]. The capabilities are encoded as
+// an object with "inheritable" and "permitted" arrays of capability names,
+// as for [State.MarshalJSON], an "effective" boolean for the effective
+// flag, and a "rootid" number for namespaced file capabilities. Empty sets,
+// an unset effective flag, and the rootid of file capabilities that are not
+// namespaced are omitted.
+func (s FileState) MarshalJSON() ([]byte, error) {
+	j := fileStateJSON{
+		Effective:   s.Effective,
+		Inheritable: s.Inheritable.names(),
+		Permitted:   s.Permitted.names(),
+	}
+	if s.Namespaced {
+		j.RootID = &s.RootID
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It accepts the format
+// produced by [FileState.MarshalJSON], and handles unknown capabilities
+// as [State.UnmarshalJSON] does.
+func (s *FileState) UnmarshalJSON(data []byte) error {
+	st, err := unmarshalFileState(data, UnknownCapKeep, maxCap)
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
+
+// UnmarshalFileState decodes file capabilities in the format produced by
+// [FileState.MarshalJSON], handling unknown capabilities according to
+// policy.
+func UnmarshalFileState(data []byte, policy UnknownCapPolicy) (FileState, error) {
+	last, err := LastCap()
+	if err != nil {
+		// Not supported on this platform; only consider the capabilities
+		// known to this package.
+		last = maxCap
+	}
+	return unmarshalFileState(data, policy, last)
+}
+
+func unmarshalFileState(data []byte, policy UnknownCapPolicy, last Cap) (FileState, error) {
+	var j fileStateJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return FileState{}, err
+	}
+	inh, err := parseNames(INHERITABLE, j.Inheritable, policy, last)
+	if err != nil {
+		return FileState{}, err
+	}
+	prm, err := parseNames(PERMITTED, j.Permitted, policy, last)
+	if err != nil {
+		return FileState{}, err
+	}
+	s := FileState{Permitted: prm, Inheritable: inh, Effective: j.Effective}
+	if j.RootID != nil {
+		if *j.RootID < 0 || int64(*j.RootID) > math.MaxUint32 {
+			return FileState{}, fmt.Errorf("invalid rootid: %d", *j.RootID)
+		}
+		s.Namespaced, s.RootID = true, *j.RootID
+	}
+	return s, nil
+}
+
+// names returns the names of the capabilities in s in the form used by the
+// OCI runtime specification, or their number for unknown capabilities.
+func (s Set) names() []string {
+	var names []string
+	for _, c := range s.List() {
+		names = append(names, strings.ToUpper(capText(c)))
+	}
+	return names
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	. "github.com/moby/sys/capability"
+)
+
+func TestStateMarshalJSON(t *testing.T) {
+	s := State{
+		Effective:   NewSet(CAP_CHOWN, CAP_NET_RAW),
+		Permitted:   NewSet(CAP_CHOWN, CAP_NET_RAW),
+		Inheritable: NewSet(CAP_NET_RAW),
+		Bounding:    NewSet(CAP_CHOWN, CAP_NET_RAW, Cap(63)),
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"bounding":["CAP_CHOWN","CAP_NET_RAW","63"],"effective":["CAP_CHOWN","CAP_NET_RAW"],"inheritable":["CAP_NET_RAW"],"permitted":["CAP_CHOWN","CAP_NET_RAW"]}`
+	if string(data) != want {
+		t.Errorf("want %s, got %s", want, data)
+	}
+
+	var got State
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != s {
+		t.Errorf("round trip: want %+v, got %+v", s, got)
+	}
+
+	data, err = json.Marshal(State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{}" {
+		t.Errorf("empty state: want {}, got %s", data)
+	}
+}
+
+func TestUnmarshalState(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		data   string
+		policy UnknownCapPolicy
+		want   State
+		err    bool
+	}{
+		{
+			name: "OCI",
+			data: `{"bounding":["CAP_CHOWN","CAP_KILL"],"effective":["CAP_KILL"],"permitted":["cap_kill"],"ambient":["kill"]}`,
+			want: State{
+				Bounding:  NewSet(CAP_CHOWN, CAP_KILL),
+				Effective: NewSet(CAP_KILL),
+				Permitted: NewSet(CAP_KILL),
+				Ambient:   NewSet(CAP_KILL),
+			},
+		},
+		{
+			name: "unknown name error",
+			data: `{"effective":["CAP_KILL","CAP_FOO"]}`,
+			err:  true,
+		},
+		{
+			name:   "unknown name ignore",
+			data:   `{"effective":["CAP_KILL","CAP_FOO"]}`,
+			policy: UnknownCapIgnore,
+			want:   State{Effective: NewSet(CAP_KILL)},
+		},
+		{
+			name:   "unknown name keep",
+			data:   `{"effective":["CAP_KILL","CAP_FOO"]}`,
+			policy: UnknownCapKeep,
+			err:    true,
+		},
+		{
+			name: "unknown number error",
+			data: `{"bounding":["CAP_KILL","63"]}`,
+			err:  true,
+		},
+		{
+			name:   "unknown number ignore",
+			data:   `{"bounding":["CAP_KILL","63"]}`,
+			policy: UnknownCapIgnore,
+			want:   State{Bounding: NewSet(CAP_KILL)},
+		},
+		{
+			name:   "unknown number keep",
+			data:   `{"bounding":["CAP_KILL","63"]}`,
+			policy: UnknownCapKeep,
+			want:   State{Bounding: NewSet(CAP_KILL, Cap(63))},
+		},
+		{
+			name: "invalid",
+			data: `{"bounding":"CAP_KILL"}`,
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := UnmarshalState([]byte(tc.data), tc.policy)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("want error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("want %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFileStateMarshalJSON(t *testing.T) {
+	s := FileState{
+		Permitted:   NewSet(CAP_NET_BIND_SERVICE),
+		Inheritable: NewSet(CAP_CHOWN),
+		Effective:   true,
+		Namespaced:  true,
+		RootID:      1000,
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"effective":true,"inheritable":["CAP_CHOWN"],"permitted":["CAP_NET_BIND_SERVICE"],"rootid":1000}`
+	if string(data) != want {
+		t.Errorf("want %s, got %s", want, data)
+	}
+	var got FileState
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != s {
+		t.Errorf("round trip: want %+v, got %+v", s, got)
+	}
+
+	// A rootid of 0 is kept for namespaced file capabilities.
+	data, err = json.Marshal(FileState{Namespaced: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"rootid":0}` {
+		t.Errorf(`want {"rootid":0}, got %s`, data)
+	}
+	data, err = json.Marshal(FileState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{}" {
+		t.Errorf("empty state: want {}, got %s", data)
+	}
+
+	for _, data := range []string{`{"rootid":-1}`, `{"rootid":4294967296}`, `{"permitted":["CAP_FOO"]}`} {
+		if _, err := UnmarshalFileState([]byte(data), UnknownCapError); err == nil {
+			t.Errorf("%s: want error, got nil", data)
+		}
+	}
+}
+
+func TestFileStateRoundTrip(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	// VFS_CAP_REVISION_3 with CAP_NET_BIND_SERVICE permitted and effective,
+	// and a rootid of 1000.
+	v3 := []byte{1, 0, 0, 3, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe8, 3, 0, 0}
+	// VFS_CAP_REVISION_2 with CAP_CHOWN inheritable, without the effective
+	// flag.
+	v2 := []byte{0, 0, 0, 2, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for _, raw := range [][]byte{v3, v2} {
+		c, err := NewFileFromBytes(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(FileStateOf(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s FileState
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		c2, err := NewFileFromBytes(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.CopyTo(c2)
+		got, err := c2.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Errorf("%s: want %x, got %x", data, raw, got)
+		}
+	}
+}
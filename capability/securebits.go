@@ -0,0 +1,68 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Securebits is a set of securebits flags, which control how the kernel
+// handles capabilities for the root user. See capabilities(7) for details.
+type Securebits uint32
+
+// Securebits flags, as defined in
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/securebits.h
+//
+// Each flag has a corresponding _LOCKED flag, which prevents the flag from
+// being changed once set.
+const (
+	// Do not grant capabilities to processes with uid 0 on execve(2).
+	SECBIT_NOROOT        = Securebits(1 << 0)
+	SECBIT_NOROOT_LOCKED = Securebits(1 << 1)
+
+	// Do not adjust capabilities when the uids of a process change
+	// to or from 0.
+	SECBIT_NO_SETUID_FIXUP        = Securebits(1 << 2)
+	SECBIT_NO_SETUID_FIXUP_LOCKED = Securebits(1 << 3)
+
+	// Keep the permitted capabilities when all uids of a process change
+	// from 0 to nonzero values. It is cleared on execve(2).
+	SECBIT_KEEP_CAPS        = Securebits(1 << 4)
+	SECBIT_KEEP_CAPS_LOCKED = Securebits(1 << 5)
+
+	// Disallow raising ambient capabilities.
+	// Introduced in kernel 4.3
+	SECBIT_NO_CAP_AMBIENT_RAISE        = Securebits(1 << 6)
+	SECBIT_NO_CAP_AMBIENT_RAISE_LOCKED = Securebits(1 << 7)
+)
+
+var securebitsNames = []string{
+	"noroot",
+	"noroot_locked",
+	"no_setuid_fixup",
+	"no_setuid_fixup_locked",
+	"keep_caps",
+	"keep_caps_locked",
+	"no_cap_ambient_raise",
+	"no_cap_ambient_raise_locked",
+}
+
+// String returns the names of the flags in b, separated by ", ".
+// Unknown flags are given as a hexadecimal number.
+func (b Securebits) String() string {
+	var names []string
+	for i, name := range securebitsNames {
+		if b&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := b &^ (1<<len(securebitsNames) - 1); rest != 0 {
+		names = append(names, "0x"+strconv.FormatUint(uint64(rest), 16))
+	}
+	return strings.Join(names, ", ")
+}
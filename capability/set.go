@@ -0,0 +1,257 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import "strings"
+
+// maxCap is the highest capability that can be stored in a [Set].
+const maxCap = Cap(63)
+
+// Set is a set of capabilities, such as the effective or permitted set of a
+// process. The zero value is an empty set.
+type Set uint64
+
+// NewSet returns a set containing the given capabilities. Capabilities that
+// can not be stored in a set (less than 0, or greater than 63) are ignored.
+func NewSet(caps ...Cap) Set {
+	var s Set
+	s.Add(caps...)
+	return s
+}
+
+// Has reports whether c is in s.
+func (s Set) Has(c Cap) bool {
+	return c >= 0 && c <= maxCap && s&(1<<uint(c)) != 0
+}
+
+// Add adds the given capabilities to s.
+func (s *Set) Add(caps ...Cap) {
+	for _, c := range caps {
+		if c >= 0 && c <= maxCap {
+			*s |= 1 << uint(c)
+		}
+	}
+}
+
+// Remove removes the given capabilities from s.
+func (s *Set) Remove(caps ...Cap) {
+	for _, c := range caps {
+		if c >= 0 && c <= maxCap {
+			*s &^= 1 << uint(c)
+		}
+	}
+}
+
+// Union returns the capabilities that are in s or in other.
+func (s Set) Union(other Set) Set {
+	return s | other
+}
+
+// Intersect returns the capabilities that are in both s and other.
+func (s Set) Intersect(other Set) Set {
+	return s & other
+}
+
+// Difference returns the capabilities that are in s but not in other.
+func (s Set) Difference(other Set) Set {
+	return s &^ other
+}
+
+// IsSubset reports whether all capabilities in s are also in other.
+func (s Set) IsSubset(other Set) bool {
+	return s&^other == 0
+}
+
+// List returns the capabilities in s, in ascending order.
+func (s Set) List() []Cap {
+	var caps []Cap
+	for c := Cap(0); c <= maxCap; c++ {
+		if s.Has(c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// String returns the names of the capabilities in s, separated by ", ",
+// similar to [Capabilities.StringCap].
+func (s Set) String() string {
+	var names []string
+	for _, c := range s.List() {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// State holds the capability sets of a process. Unlike [Capabilities], it
+// is a plain value which is not associated with a process or file.
+type State struct {
+	Effective, Permitted, Inheritable, Bounding, Ambient Set
+}
+
+// set returns a pointer to the set of s selected by which, which must be one
+// of EFFECTIVE, PERMITTED, INHERITABLE, BOUNDING or AMBIENT.
+func (s *State) set(which CapType) *Set {
+	switch which {
+	case EFFECTIVE:
+		return &s.Effective
+	case PERMITTED:
+		return &s.Permitted
+	case INHERITABLE:
+		return &s.Inheritable
+	case BOUNDING:
+		return &s.Bounding
+	case AMBIENT:
+		return &s.Ambient
+	}
+	return nil
+}
+
+// StateOf returns the capability sets of c. It does not call
+// [Capabilities.Load].
+func StateOf(c Capabilities) State {
+	var s State
+	for which := EFFECTIVE; which <= AMBIENT; which <<= 1 {
+		set := s.set(which)
+		for i := Cap(0); i <= maxCap; i++ {
+			if c.Get(which, i) {
+				set.Add(i)
+			}
+		}
+	}
+	return s
+}
+
+// CopyTo replaces the capability sets of c selected by which with those of
+// s. The 'which' value should be one or combination (OR'ed) of EFFECTIVE,
+// PERMITTED, INHERITABLE, BOUNDING or AMBIENT. As with [Capabilities.Set],
+// the changes take effect on [Capabilities.Apply].
+func (s State) CopyTo(c Capabilities, which CapType) {
+	all := make([]Cap, 0, maxCap+1)
+	for i := Cap(0); i <= maxCap; i++ {
+		all = append(all, i)
+	}
+	for w := EFFECTIVE; w <= AMBIENT; w <<= 1 {
+		if which&w == 0 {
+			continue
+		}
+		c.Unset(w, all...)
+		c.Set(w, s.set(w).List()...)
+	}
+}
+
+// FileState holds the capabilities of a file as a plain value. Unlike
+// [State], it keeps the effective flag and the rootid of namespaced file
+// capabilities, so that converting [FileCapabilities] to a FileState and
+// back does not change the security.capability extended attribute.
+type FileState struct {
+	// Permitted and Inheritable are the permitted and inheritable sets of
+	// the file.
+	Permitted, Inheritable Set
+	// Effective is the effective flag of the file. If set, the permitted
+	// capabilities gained on execve(2) are also raised in the effective set.
+	Effective bool
+	// Namespaced is true for namespaced file capabilities
+	// (VFS_CAP_REVISION_3), which only apply in the user namespace whose
+	// root is mapped to RootID.
+	Namespaced bool
+	// RootID is the rootid of namespaced file capabilities.
+	RootID int
+}
+
+// FileStateOf returns the capabilities of c. It does not call
+// [Capabilities.Load].
+func FileStateOf(c FileCapabilities) FileState {
+	s := StateOf(c)
+	f := FileState{
+		Permitted:   s.Permitted,
+		Inheritable: s.Inheritable,
+		Effective:   s.Effective != 0,
+	}
+	f.RootID, f.Namespaced = c.RootID()
+	return f
+}
+
+// CopyTo replaces the capabilities of c with those of s. As with
+// [Capabilities.Set], the changes take effect on [Capabilities.Apply].
+//
+// The effective flag is only kept if the permitted or inheritable set is
+// not empty, as it is stored as part of the effective set.
+func (s FileState) CopyTo(c FileCapabilities) {
+	st := State{Permitted: s.Permitted, Inheritable: s.Inheritable}
+	if s.Effective {
+		st.Effective = s.Permitted | s.Inheritable
+	}
+	st.CopyTo(c, CAPS)
+	if s.Namespaced {
+		c.SetRootID(s.RootID)
+	} else {
+		c.ClearRootID()
+	}
+}
+
+// StateDiff holds the differences between two capability states, as
+// returned by [Diff] and [State.Diff].
+type StateDiff struct {
+	// Added holds, for each set, the capabilities that are only in the
+	// second state.
+	Added State
+	// Removed holds, for each set, the capabilities that are only in the
+	// first state.
+	Removed State
+}
+
+// Diff returns the differences between the capability sets of a and b. For
+// example, to find the capabilities that a process has beyond a policy:
+//
+//	d := capability.Diff(policy, process)
+//	fmt.Println(d.Added.Effective) // e.g. "sys_admin"
+//
+// Diff does not call [Capabilities.Load].
+func Diff(a, b Capabilities) StateDiff {
+	return StateOf(a).Diff(StateOf(b))
+}
+
+// Diff returns the differences between s and other. Capabilities that are
+// only in other are added, and capabilities that are only in s are removed.
+func (s State) Diff(other State) StateDiff {
+	var d StateDiff
+	for w := EFFECTIVE; w <= AMBIENT; w <<= 1 {
+		*d.Added.set(w) = other.set(w).Difference(*s.set(w))
+		*d.Removed.set(w) = s.set(w).Difference(*other.set(w))
+	}
+	return d
+}
+
+// Empty reports whether there are no differences.
+func (d StateDiff) Empty() bool {
+	return d.Added == State{} && d.Removed == State{}
+}
+
+// String returns a description of the differences, listing for each set
+// that differs the added capabilities prefixed with "+", and the removed
+// ones prefixed with "-", for example:
+//
+//	effective: +sys_admin, -chown; bounding: -chown
+//
+// It returns an empty string if there are no differences.
+func (d StateDiff) String() string {
+	var sets []string
+	for w := EFFECTIVE; w <= AMBIENT; w <<= 1 {
+		var caps []string
+		for _, c := range d.Added.set(w).List() {
+			caps = append(caps, "+"+c.String())
+		}
+		for _, c := range d.Removed.set(w).List() {
+			caps = append(caps, "-"+c.String())
+		}
+		if len(caps) > 0 {
+			sets = append(sets, w.String()+": "+strings.Join(caps, ", "))
+		}
+	}
+	return strings.Join(sets, "; ")
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import "strconv"
+
+// SeccompMode is the seccomp mode of a thread. See seccomp(2).
+type SeccompMode int
+
+const (
+	SeccompDisabled = SeccompMode(0)
+	SeccompStrict   = SeccompMode(1)
+	SeccompFilter   = SeccompMode(2)
+)
+
+// String returns the name of the mode, as in the SECCOMP_MODE_* constants,
+// in lower case.
+func (m SeccompMode) String() string {
+	switch m {
+	case SeccompDisabled:
+		return "disabled"
+	case SeccompStrict:
+		return "strict"
+	case SeccompFilter:
+		return "filter"
+	}
+	return "unknown (" + strconv.Itoa(int(m)) + ")"
+}
+
+// ThreadSnapshot holds the security attributes of a thread, as returned by
+// [Snapshot].
+type ThreadSnapshot struct {
+	// TID is the thread ID.
+	TID int
+	// State holds the capability sets of the thread.
+	State State
+	// NoNewPrivs is the no_new_privs flag of the thread.
+	NoNewPrivs bool
+	// Seccomp is the seccomp mode of the thread.
+	Seccomp SeccompMode
+	// Differs is true if any of the above, except TID, is different from
+	// the thread group leader.
+	Differs bool
+}
+
+// ProcessSnapshot holds the security attributes of all threads of a
+// process, as returned by [Snapshot].
+type ProcessSnapshot struct {
+	// PID is the process ID.
+	PID int
+	// Threads holds the threads of the process, in ascending order of
+	// thread ID. The thread group leader, whose TID is PID, comes first.
+	Threads []ThreadSnapshot
+}
+
+// Leader returns the thread group leader, which is the first thread.
+func (p *ProcessSnapshot) Leader() *ThreadSnapshot {
+	return &p.Threads[0]
+}
+
+// Differs reports whether any thread has different capabilities,
+// no_new_privs flag or seccomp mode than the thread group leader.
+func (p *ProcessSnapshot) Differs() bool {
+	for _, t := range p.Threads {
+		if t.Differs {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns the capability sets, no_new_privs flag and seccomp mode
+// of all threads of the process identified by pid (0 means the current
+// process), as read from /proc/<pid>/task/<tid>/status. Unlike
+// [Capabilities.Load], which only reads the attributes of the thread
+// group leader, it shows threads whose attributes were changed on their
+// own, for example by [Capabilities.Apply].
+//
+// Threads that exit while the snapshot is taken are omitted. Snapshot is
+// only supported on Linux.
+func Snapshot(pid int) (*ProcessSnapshot, error) {
+	return snapshot(pid)
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+func snapshot(pid int) (*ProcessSnapshot, error) {
+	if pid == 0 {
+		pid = os.Getpid()
+	}
+	dir := "/proc/" + strconv.Itoa(pid) + "/task"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProcessSnapshot{PID: pid}
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(dir + "/" + e.Name() + "/status")
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && tid != pid {
+				// The thread has exited.
+				continue
+			}
+			return nil, err
+		}
+		st, err := parseStatus(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		p.Threads = append(p.Threads, ThreadSnapshot{
+			TID:        tid,
+			State:      st.state,
+			NoNewPrivs: st.noNewPrivs,
+			Seccomp:    st.seccomp,
+		})
+	}
+
+	sort.Slice(p.Threads, func(i, j int) bool {
+		// The leader comes first.
+		if ti, tj := p.Threads[i].TID, p.Threads[j].TID; ti == pid || tj == pid {
+			return ti == pid && tj != pid
+		}
+		return p.Threads[i].TID < p.Threads[j].TID
+	})
+	if len(p.Threads) == 0 || p.Threads[0].TID != pid {
+		return nil, fmt.Errorf("thread group leader not found in %s", dir)
+	}
+	leader := p.Threads[0]
+	for i := range p.Threads[1:] {
+		t := &p.Threads[i+1]
+		t.Differs = t.State != leader.State || t.NoNewPrivs != leader.NoNewPrivs || t.Seccomp != leader.Seccomp
+	}
+	return p, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseStatus parses the capability sets from the content of a
+// /proc/<pid>/status file, given by the CapInh, CapPrm, CapEff, CapBnd and
+// CapAmb fields. Sets that are missing, such as the ambient set on kernels
+// before 4.3, are empty.
+func ParseStatus(r io.Reader) (State, error) {
+	st, err := parseStatus(r)
+	if err != nil {
+		return State{}, err
+	}
+	return st.state, nil
+}
+
+// threadStatus holds the attributes of a thread that are parsed from
+// /proc/<pid>/task/<tid>/status.
+type threadStatus struct {
+	state      State
+	noNewPrivs bool
+	seccomp    SeccompMode
+}
+
+func parseStatus(r io.Reader) (st threadStatus, err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, val, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		var set *Set
+		switch key {
+		case "CapInh":
+			set = &st.state.Inheritable
+		case "CapPrm":
+			set = &st.state.Permitted
+		case "CapEff":
+			set = &st.state.Effective
+		case "CapBnd":
+			set = &st.state.Bounding
+		case "CapAmb":
+			set = &st.state.Ambient
+		case "NoNewPrivs":
+			st.noNewPrivs = val == "1"
+			continue
+		case "Seccomp":
+			mode, err := strconv.Atoi(val)
+			if err != nil {
+				return st, fmt.Errorf("invalid %s value: %w", key, err)
+			}
+			st.seccomp = SeccompMode(mode)
+			continue
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(val, 16, 64)
+		if err != nil {
+			return st, fmt.Errorf("invalid %s value: %w", key, err)
+		}
+		*set = Set(v)
+	}
+	return st, s.Err()
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/moby/sys/capability"
+)
+
+const testStatus = `Name:	cat
+Umask:	0022
+State:	R (running)
+Tgid:	1234
+Pid:	1234
+CapInh:	0000000000000400
+CapPrm:	00000000a80425fb
+CapEff:	00000000a80425fb
+CapBnd:	000001ffffffffff
+CapAmb:	0000000000000000
+NoNewPrivs:	1
+Seccomp:	2
+Seccomp_filters:	1
+`
+
+func TestParseStatus(t *testing.T) {
+	want := State{
+		Inheritable: Set(0x400),
+		Permitted:   Set(0xa80425fb),
+		Effective:   Set(0xa80425fb),
+		Bounding:    Set(0x1ffffffffff),
+	}
+	got, err := ParseStatus(strings.NewReader(testStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	// Kernels before 4.3 do not have CapAmb.
+	got, err = ParseStatus(strings.NewReader("CapBnd:\t0000000000000003\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (State{Bounding: NewSet(CAP_CHOWN, CAP_DAC_OVERRIDE)}); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if _, err := ParseStatus(strings.NewReader("CapEff:\tnothex\n")); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestNewPidFromProcfs(t *testing.T) {
+	procfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(procfs, "1234"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(procfs, "1234", "status"), []byte(testStatus), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewPidFromProcfs(procfs, 1234)
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error(runtime.GOOS, ": want error, got nil")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParseStatus(strings.NewReader(testStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := StateOf(c); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+	if !c.(ProcessCapabilities).NoNewPrivs() {
+		t.Error("NoNewPrivs: want true, got false")
+	}
+	if err := c.Apply(CAPS); err == nil {
+		t.Error("Apply: want error, got nil")
+	}
+
+	if _, err := NewPidFromProcfs("", 1234); err == nil {
+		t.Error("empty procfs: want error, got nil")
+	}
+
+	// Loading from /proc should give the same result as NewPid2.
+	c, err = NewPidFromProcfs("/proc", os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewPid2(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := StateOf(c), StateOf(c2); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
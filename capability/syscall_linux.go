@@ -8,6 +8,8 @@
 package capability
 
 import (
+	"encoding/binary"
+	"os"
 	"syscall"
 	"unsafe"
 )
@@ -31,8 +33,16 @@ func capget(hdr *capHeader, data *capData) (err error) {
 	return
 }
 
+// syscallFunc is the signature of [syscall.RawSyscall] and
+// [syscall.AllThreadsSyscall].
+type syscallFunc func(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err syscall.Errno)
+
 func capset(hdr *capHeader, data *capData) (err error) {
-	_, _, e1 := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(data)), 0)
+	return capsetWith(syscall.RawSyscall, hdr, data)
+}
+
+func capsetWith(sys syscallFunc, hdr *capHeader, data *capData) (err error) {
+	_, _, e1 := sys(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(data)), 0)
 	if e1 != 0 {
 		err = e1
 	}
@@ -46,10 +56,17 @@ const (
 	pr_CAP_AMBIENT_RAISE     = uintptr(2)
 	pr_CAP_AMBIENT_LOWER     = uintptr(3)
 	pr_CAP_AMBIENT_CLEAR_ALL = uintptr(4)
+
+	pr_SET_NO_NEW_PRIVS = 38
+	pr_GET_NO_NEW_PRIVS = 39
 )
 
 func prctl(option int, arg2, arg3 uintptr) (err error) {
-	_, _, e1 := syscall.RawSyscall(syscall.SYS_PRCTL, uintptr(option), arg2, arg3)
+	return prctlWith(syscall.RawSyscall, option, arg2, arg3)
+}
+
+func prctlWith(sys syscallFunc, option int, arg2, arg3 uintptr) (err error) {
+	_, _, e1 := sys(syscall.SYS_PRCTL, uintptr(option), arg2, arg3)
 	if e1 != 0 {
 		err = e1
 	}
@@ -70,12 +87,14 @@ const (
 	vfsCapVerMask = 0xff000000
 	vfsCapVer1    = 0x01000000
 	vfsCapVer2    = 0x02000000
+	vfsCapVer3    = 0x03000000
 
 	vfsCapFlagMask      = ^vfsCapVerMask
 	vfsCapFlageffective = 0x000001
 
 	vfscapDataSizeV1 = 4 * (1 + 2*1)
 	vfscapDataSizeV2 = 4 * (1 + 2*2)
+	vfscapDataSizeV3 = 4 * (1 + 2*2 + 1)
 )
 
 type vfscapData struct {
@@ -85,9 +104,80 @@ type vfscapData struct {
 		inheritable uint32
 	}
 	effective [2]uint32
+	rootid    uint32 // Only used by version 3.
 	version   int8
 }
 
+// unmarshal parses the value of a security.capability xattr, which is
+// stored in little-endian byte order (struct vfs_ns_cap_data in the kernel).
+func (d *vfscapData) unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return syscall.EINVAL
+	}
+	magic := binary.LittleEndian.Uint32(b)
+	var (
+		version int8
+		size    int
+	)
+	switch magic & vfsCapVerMask {
+	case vfsCapVer1:
+		version, size = 1, vfscapDataSizeV1
+	case vfsCapVer2:
+		version, size = 2, vfscapDataSizeV2
+	case vfsCapVer3:
+		version, size = 3, vfscapDataSizeV3
+	default:
+		return syscall.EINVAL
+	}
+	if len(b) != size {
+		return syscall.EINVAL
+	}
+	*d = vfscapData{magic: magic, version: version}
+	for i := 0; i < (size-4)/8; i++ {
+		d.data[i].permitted = binary.LittleEndian.Uint32(b[4+8*i:])
+		d.data[i].inheritable = binary.LittleEndian.Uint32(b[8+8*i:])
+	}
+	if version == 3 {
+		d.rootid = binary.LittleEndian.Uint32(b[vfscapDataSizeV2:])
+	}
+	if magic&vfsCapFlageffective != 0 {
+		d.effective[0] = d.data[0].permitted | d.data[0].inheritable
+		d.effective[1] = d.data[1].permitted | d.data[1].inheritable
+	}
+	return nil
+}
+
+// marshal returns the value of a security.capability xattr for d.
+func (d *vfscapData) marshal() ([]byte, error) {
+	var size int
+	switch d.version {
+	case 1:
+		d.magic = vfsCapVer1
+		size = vfscapDataSizeV1
+	case 2:
+		d.magic = vfsCapVer2
+		size = vfscapDataSizeV2
+	case 3:
+		d.magic = vfsCapVer3
+		size = vfscapDataSizeV3
+	default:
+		return nil, syscall.EINVAL
+	}
+	if d.version != 1 && (d.effective[0] != 0 || d.effective[1] != 0) {
+		d.magic |= vfsCapFlageffective
+	}
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b, d.magic)
+	for i := 0; i < (size-4)/8; i++ {
+		binary.LittleEndian.PutUint32(b[4+8*i:], d.data[i].permitted)
+		binary.LittleEndian.PutUint32(b[8+8*i:], d.data[i].inheritable)
+	}
+	if d.version == 3 {
+		binary.LittleEndian.PutUint32(b[vfscapDataSizeV2:], d.rootid)
+	}
+	return b, nil
+}
+
 var _vfsXattrName *byte
 
 func init() {
@@ -100,38 +190,45 @@ func getVfsCap(path string, dest *vfscapData) (err error) {
 	if err != nil {
 		return
 	}
-	r0, _, e1 := syscall.RawSyscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(dest)), vfscapDataSizeV2, 0, 0)
-	if e1 != 0 {
-		if e1 == syscall.ENODATA {
-			dest.version = 2
-			return
-		}
-		err = e1
+	var buf [vfscapDataSizeV3]byte
+	r0, _, e1 := syscall.RawSyscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	return loadVfsCap(dest, buf[:], r0, e1)
+}
+
+func fgetVfsCap(f *os.File, dest *vfscapData) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
 	}
-	switch dest.magic & vfsCapVerMask {
-	case vfsCapVer1:
-		dest.version = 1
-		if r0 != vfscapDataSizeV1 {
-			return syscall.EINVAL
-		}
-		dest.data[1].permitted = 0
-		dest.data[1].inheritable = 0
-	case vfsCapVer2:
-		dest.version = 2
-		if r0 != vfscapDataSizeV2 {
+	var (
+		buf [vfscapDataSizeV3]byte
+		r0  uintptr
+		e1  syscall.Errno
+	)
+	err = rc.Control(func(fd uintptr) {
+		r0, _, e1 = syscall.RawSyscall6(syscall.SYS_FGETXATTR, fd, uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return loadVfsCap(dest, buf[:], r0, e1)
+}
+
+// loadVfsCap loads dest from the result of getxattr or fgetxattr, which
+// read n bytes into buf.
+func loadVfsCap(dest *vfscapData, buf []byte, n uintptr, e1 syscall.Errno) error {
+	if e1 != 0 {
+		switch e1 {
+		case syscall.ENODATA:
+			*dest = vfscapData{version: 2}
+			return nil
+		case syscall.ERANGE:
+			// Larger than any known version.
 			return syscall.EINVAL
 		}
-	default:
-		return syscall.EINVAL
-	}
-	if dest.magic&vfsCapFlageffective != 0 {
-		dest.effective[0] = dest.data[0].permitted | dest.data[0].inheritable
-		dest.effective[1] = dest.data[1].permitted | dest.data[1].inheritable
-	} else {
-		dest.effective[0] = 0
-		dest.effective[1] = 0
+		return e1
 	}
-	return
+	return dest.unmarshal(buf[:n])
 }
 
 func setVfsCap(path string, data *vfscapData) (err error) {
@@ -140,23 +237,35 @@ func setVfsCap(path string, data *vfscapData) (err error) {
 	if err != nil {
 		return
 	}
-	var size uintptr
-	switch data.version {
-	case 1:
-		data.magic = vfsCapVer1
-		size = vfscapDataSizeV1
-	case 2:
-		data.magic = vfsCapVer2
-		if data.effective[0] != 0 || data.effective[1] != 0 {
-			data.magic |= vfsCapFlageffective
-		}
-		size = vfscapDataSizeV2
-	default:
-		return syscall.EINVAL
+	b, err := data.marshal()
+	if err != nil {
+		return err
 	}
-	_, _, e1 := syscall.RawSyscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(data)), size, 0, 0)
+	_, _, e1 := syscall.RawSyscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0, 0)
 	if e1 != 0 {
 		err = e1
 	}
 	return
 }
+
+func fsetVfsCap(f *os.File, data *vfscapData) error {
+	b, err := data.marshal()
+	if err != nil {
+		return err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var e1 syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, e1 = syscall.RawSyscall6(syscall.SYS_FSETXATTR, fd, uintptr(unsafe.Pointer(_vfsXattrName)), uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestVfsCapData(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want vfscapData
+	}{
+		{
+			name: "v1",
+			data: []byte{0, 0, 0, 1, 0x01, 0, 0, 0, 0x02, 0, 0, 0},
+			want: vfscapData{version: 1, data: [2]struct{ permitted, inheritable uint32 }{{1, 2}}},
+		},
+		{
+			name: "v2 effective",
+			data: []byte{1, 0, 0, 2, 0, 0x04, 0, 0, 0, 0, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 0},
+			want: vfscapData{version: 2, data: [2]struct{ permitted, inheritable uint32 }{{0x400, 0}, {1, 0}}, effective: [2]uint32{0x400, 1}},
+		},
+		{
+			name: "v3",
+			data: []byte{0, 0, 0, 3, 0, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe8, 0x03, 0, 0},
+			want: vfscapData{version: 3, data: [2]struct{ permitted, inheritable uint32 }{{0x400, 0}}, rootid: 1000},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var d vfscapData
+			if err := d.unmarshal(tc.data); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			d.magic = 0
+			if d != tc.want {
+				t.Errorf("unmarshal: want %+v, got %+v", tc.want, d)
+			}
+			b, err := d.marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if !bytes.Equal(b, tc.data) {
+				t.Errorf("marshal: want %x, got %x", tc.data, b)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		{0, 0, 0, 1},
+		{0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0},
+		make([]byte, vfscapDataSizeV3),
+		append([]byte{0, 0, 0, 3}, make([]byte, vfscapDataSizeV2-4)...),
+	} {
+		var d vfscapData
+		if err := d.unmarshal(data); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("unmarshal(%x): want EINVAL, got %v", data, err)
+		}
+	}
+}
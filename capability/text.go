@@ -0,0 +1,224 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var capByName = sync.OnceValue(func() map[string]Cap {
+	m := make(map[string]Cap)
+	for _, c := range list() {
+		m[c.String()] = c
+	}
+	return m
+})
+
+// ParseCap returns the capability with the given name. The name is case
+// insensitive, and may or may not have a "cap_" prefix, so that the libcap
+// ("cap_net_raw"), OCI ("CAP_NET_RAW") and [Cap.String] ("net_raw") forms
+// are all accepted. A capability may also be given by its number, which
+// allows capabilities that are not known to this package.
+func ParseCap(name string) (Cap, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 0 || Cap(n) > maxCap {
+			return 0, fmt.Errorf("invalid capability number: %d", n)
+		}
+		return Cap(n), nil
+	}
+	lower := strings.ToLower(name)
+	if c, ok := capByName()[strings.TrimPrefix(lower, "cap_")]; ok {
+		return c, nil
+	}
+	return 0, fmt.Errorf("unknown capability: %q", name)
+}
+
+// ParseText parses capability sets in the textual form accepted by libcap's
+// cap_from_text(3), such as "cap_net_raw,cap_net_admin+ep cap_sys_admin-e".
+//
+// The text is a list of clauses separated by whitespace. Each clause is a
+// comma-separated list of capabilities (as accepted by [ParseCap], or "all"
+// for all capabilities known to this package), followed by one or more
+// operations. An operation is one of "=", "+" or "-", followed by one or more
+// of the flags "e", "i" and "p", which select the effective, inheritable and
+// permitted sets. "=" first removes the capabilities from all three sets,
+// and then adds them to the selected sets, if any. If the list of
+// capabilities is empty, as in "=ep", it applies to all capabilities.
+//
+// Only the effective, permitted and inheritable sets of the returned State
+// are set.
+func ParseText(text string) (State, error) {
+	var s State
+	for _, clause := range strings.Fields(text) {
+		if err := s.parseClause(clause); err != nil {
+			return State{}, fmt.Errorf("invalid capability text %q: %w", clause, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *State) parseClause(clause string) error {
+	i := strings.IndexAny(clause, "=+-")
+	if i < 0 {
+		return fmt.Errorf("no operation")
+	}
+	var caps []Cap
+	if i == 0 {
+		if clause[0] != '=' {
+			return fmt.Errorf("no capabilities")
+		}
+		caps = list()
+	}
+	if i > 0 {
+		for _, name := range strings.Split(clause[:i], ",") {
+			if name == "all" {
+				caps = append(caps, list()...)
+				continue
+			}
+			c, err := ParseCap(name)
+			if err != nil {
+				return err
+			}
+			caps = append(caps, c)
+		}
+	}
+
+	for ops := clause[i:]; ops != ""; {
+		op := ops[0]
+		j := 1
+		for j < len(ops) && strings.IndexByte("eip", ops[j]) >= 0 {
+			j++
+		}
+		flags := ops[1:j]
+		ops = ops[j:]
+		if op != '=' && op != '+' && op != '-' {
+			return fmt.Errorf("invalid operation %q", op)
+		}
+		if flags == "" && op != '=' {
+			return fmt.Errorf("no flags for operation %q", op)
+		}
+		if op == '=' {
+			s.Effective.Remove(caps...)
+			s.Permitted.Remove(caps...)
+			s.Inheritable.Remove(caps...)
+		}
+		for _, f := range flags {
+			set := s.set(textFlags[f])
+			if op == '-' {
+				set.Remove(caps...)
+			} else {
+				set.Add(caps...)
+			}
+		}
+	}
+	return nil
+}
+
+var textFlags = map[rune]CapType{'e': EFFECTIVE, 'i': INHERITABLE, 'p': PERMITTED}
+
+// Flags of a capability in the textual form, numbered as in libcap, which
+// determines the order of clauses in [State.Text].
+const (
+	textEff = 1 << iota
+	textInh
+	textPer
+)
+
+// textFlagsOf returns the flags of c in s.
+func (s State) textFlagsOf(c Cap) int {
+	var f int
+	if s.Effective.Has(c) {
+		f |= textEff
+	}
+	if s.Inheritable.Has(c) {
+		f |= textInh
+	}
+	if s.Permitted.Has(c) {
+		f |= textPer
+	}
+	return f
+}
+
+func flagsText(f int) string {
+	var b strings.Builder
+	for i, c := range "eip" {
+		if f&(1<<i) != 0 {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func capText(c Cap) string {
+	if c.String() == "unknown" {
+		return strconv.Itoa(int(c))
+	}
+	return "cap_" + c.String()
+}
+
+// Text returns the effective, permitted and inheritable sets of s in the
+// canonical textual form produced by libcap's cap_to_text(3), which can be
+// parsed by [ParseText]. The most common combination of flags among the
+// capabilities known to this package is given first, as in "=ep", followed
+// by clauses for capabilities with other flags, as in "=ep cap_sys_admin-e".
+// Capabilities that are not known to this package are given by their number.
+func (s State) Text() string {
+	known := list()
+	var histo [textEff | textInh | textPer + 1]int
+	for _, c := range known {
+		histo[s.textFlagsOf(c)]++
+	}
+	base := len(histo) - 1
+	for f := base - 1; f >= 0; f-- {
+		if histo[f] >= histo[base] {
+			base = f
+		}
+	}
+
+	var clauses []string
+	if base != 0 {
+		clauses = append(clauses, "="+flagsText(base))
+	}
+	for f := len(histo) - 1; f >= 0; f-- {
+		if f == base || histo[f] == 0 {
+			continue
+		}
+		var names []string
+		for _, c := range known {
+			if s.textFlagsOf(c) == f {
+				names = append(names, capText(c))
+			}
+		}
+		clause := strings.Join(names, ",")
+		if add := f &^ base; add != 0 {
+			// Without a base, the first clause is given as "cap_x=ep"
+			// rather than "= cap_x+ep", as cap_to_text(3) does.
+			op := "+"
+			if len(clauses) == 0 {
+				op = "="
+			}
+			clause += op + flagsText(add)
+		}
+		if del := base &^ f; del != 0 {
+			clause += "-" + flagsText(del)
+		}
+		clauses = append(clauses, clause)
+	}
+	// Capabilities not known to this package are not covered by "=".
+	for c := Cap(0); c <= maxCap; c++ {
+		if f := s.textFlagsOf(c); f != 0 && c.String() == "unknown" {
+			clauses = append(clauses, capText(c)+"="+flagsText(f))
+		}
+	}
+	if len(clauses) == 0 {
+		return "="
+	}
+	return strings.Join(clauses, " ")
+}
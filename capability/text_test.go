@@ -0,0 +1,137 @@
+// Copyright 2024 The Capability Authors.
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package capability_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/moby/sys/capability"
+)
+
+func TestParseCap(t *testing.T) {
+	for _, c := range ListKnown() {
+		name := c.String()
+		for _, s := range []string{name, "cap_" + name, "CAP_" + strings.ToUpper(name), strconv.Itoa(int(c))} {
+			got, err := ParseCap(s)
+			if err != nil {
+				t.Errorf("ParseCap(%q): %v", s, err)
+			} else if got != c {
+				t.Errorf("ParseCap(%q): want %d, got %d", s, c, got)
+			}
+		}
+	}
+	for _, s := range []string{"", "cap_", "cap_foo", "-1", "64", "all"} {
+		if _, err := ParseCap(s); err == nil {
+			t.Errorf("ParseCap(%q): want error, got nil", s)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	all := NewSet(ListKnown()...)
+	for _, tc := range []struct {
+		text string
+		want State
+	}{
+		{text: "", want: State{}},
+		{text: "=", want: State{}},
+		{text: "=ep", want: State{Effective: all, Permitted: all}},
+		{text: "all=i", want: State{Inheritable: all}},
+		{
+			text: "cap_net_raw,cap_net_admin+ep cap_sys_admin-e",
+			want: State{Effective: NewSet(CAP_NET_RAW, CAP_NET_ADMIN), Permitted: NewSet(CAP_NET_RAW, CAP_NET_ADMIN)},
+		},
+		{
+			text: "=ep cap_sys_admin-e",
+			want: State{Effective: NewSet(ListKnown()...) &^ NewSet(CAP_SYS_ADMIN), Permitted: all},
+		},
+		{
+			text: "CAP_CHOWN=p+e-p 41+i",
+			want: State{Effective: NewSet(CAP_CHOWN), Inheritable: NewSet(41)},
+		},
+		{
+			text: "cap_chown=eip cap_chown=",
+			want: State{},
+		},
+	} {
+		got, err := ParseText(tc.text)
+		if err != nil {
+			t.Errorf("ParseText(%q): %v", tc.text, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseText(%q): want %+v, got %+v", tc.text, tc.want, got)
+		}
+	}
+
+	for _, text := range []string{"cap_chown", "cap_chown+", "+ep", "cap_chown+x", "cap_foo=e", ",cap_chown=e", "cap_chown=e*"} {
+		if _, err := ParseText(text); err == nil {
+			t.Errorf("ParseText(%q): want error, got nil", text)
+		}
+	}
+}
+
+func TestStateText(t *testing.T) {
+	all := NewSet(ListKnown()...)
+	for _, tc := range []struct {
+		state State
+		want  string
+	}{
+		{state: State{}, want: "="},
+		{state: State{Bounding: all}, want: "="},
+		{state: State{Effective: all, Permitted: all}, want: "=ep"},
+		{state: State{Effective: NewSet(CAP_CHOWN), Permitted: NewSet(CAP_CHOWN)}, want: "cap_chown=ep"},
+		{
+			state: State{Effective: NewSet(CAP_CHOWN), Permitted: NewSet(CAP_CHOWN, CAP_NET_RAW)},
+			want:  "cap_chown=ep cap_net_raw+p",
+		},
+		{
+			state: State{Effective: all &^ NewSet(CAP_SYS_ADMIN), Permitted: all, Inheritable: NewSet(CAP_KILL)},
+			want:  "=ep cap_kill+i cap_sys_admin-e",
+		},
+		{state: State{Permitted: NewSet(CAP_CHOWN, 63)}, want: "cap_chown=p 63=p"},
+	} {
+		got := tc.state.Text()
+		if got != tc.want {
+			t.Errorf("%+v: want %q, got %q", tc.state, tc.want, got)
+		}
+	}
+}
+
+func TestStateTextRoundTrip(t *testing.T) {
+	known := ListKnown()
+	for i, c := range known {
+		// Use a different combination of flags for every capability.
+		var s State
+		for j, k := range known {
+			switch (i + j) % 5 {
+			case 0:
+				s.Effective.Add(k)
+				s.Permitted.Add(k)
+			case 1:
+				s.Permitted.Add(k)
+			case 2:
+				s.Inheritable.Add(k)
+			case 3:
+				s.Effective.Add(k)
+				s.Permitted.Add(k)
+				s.Inheritable.Add(k)
+			}
+		}
+		s.Effective.Remove(c)
+		text := s.Text()
+		got, err := ParseText(text)
+		if err != nil {
+			t.Fatalf("ParseText(%q): %v", text, err)
+		}
+		if got != s {
+			t.Errorf("round trip of %+v via %q: got %+v", s, text, got)
+		}
+	}
+}